@@ -4,5 +4,9 @@ go 1.17
 
 require (
 	github.com/akamensky/argparse v1.4.0
-	golang.org/x/net v0.4.0 // indirect
+	golang.org/x/net v0.4.0
+	golang.org/x/term v0.10.0
+	gopkg.in/yaml.v3 v3.0.1
 )
+
+require golang.org/x/sys v0.10.0 // indirect
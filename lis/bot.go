@@ -0,0 +1,330 @@
+package lis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a text command received by an adapter. From is the name of the
+// sender in the chat, it's added to the description of the bookings.
+type Message struct {
+	From string
+	Text string
+}
+
+// BotAdapter connects the bot to a chat: it receives the messages, passes
+// them to handle and delivers the replies, until the chat is over.
+type BotAdapter interface {
+	Serve(handle func(Message) string) error
+}
+
+const botHelp = `Commands:
+  show [day|date]                free slots of the week or the day
+  book <day|date> <time> [court] book the slot, e.g. "book tue 18:00"
+  cancel <id>                    cancel the booking
+  mine                           my bookings of the week
+Days are mon..sun, today or tomorrow; dates are YYYY-MM-DD.`
+
+// Bot turns short text commands into the schedule operations and formats
+// the replies. Handle takes the messages one at a time, see refreshWeek.
+type Bot struct {
+	session     *Client
+	sched       *Schedule
+	lock        sync.Mutex
+	zone        *time.Location
+	Courts      []string
+	Description string
+}
+
+func NewBot(session *Client) (*Bot, error) {
+	sched, err := NewSchedule(session)
+	if err != nil {
+		return nil, err
+	}
+	return &Bot{
+		session:     session,
+		sched:       sched,
+		Description: "To Play",
+	}, nil
+}
+
+// Schedule gives access to the schedule of the bot, e.g. to set the rules
+// before an adapter starts passing the messages.
+func (bot *Bot) Schedule() *Schedule {
+	return bot.sched
+}
+
+func (bot *Bot) Handle(message Message) string {
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
+	words := strings.Fields(message.Text)
+	if len(words) == 0 {
+		return botHelp
+	}
+	command, args := strings.ToLower(words[0]), words[1:]
+	var reply string
+	var err error
+	switch command {
+	case "show":
+		reply, err = bot.show(args)
+	case "book":
+		reply, err = bot.book(args, message.From)
+	case "cancel":
+		reply, err = bot.cancel(args)
+	case "mine":
+		reply, err = bot.mine()
+	case "help":
+		reply = botHelp
+	default:
+		reply = fmt.Sprintf("Unknown command %q.\n%s", command, botHelp)
+	}
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	return reply
+}
+
+// parseDay accepts a day name, today, tomorrow or a date. The date is empty
+// for day names, they mean the current week. Today is the one of the
+// timezone of the group.
+func (bot *Bot) parseDay(value string) (string, string, error) {
+	value = strings.ToLower(value)
+	if value == "today" || value == "tomorrow" {
+		location, err := bot.location()
+		if err != nil {
+			return "", "", err
+		}
+		now := time.Now().In(location)
+		if value == "tomorrow" {
+			now = now.AddDate(0, 0, 1)
+		}
+		return dayNames[now.Weekday()], now.Format("2006-01-02"), nil
+	}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if len(value) >= 3 && strings.HasPrefix(strings.ToLower(day.String()), value) {
+			return dayNames[day], "", nil
+		}
+	}
+	date, err := parseDate("date", value)
+	if err != nil {
+		return "", "", fmt.Errorf("unknown day %q", value)
+	}
+	return dayNames[date.Weekday()], value, nil
+}
+
+// location returns the timezone of the group, it's read once.
+func (bot *Bot) location() (*time.Location, error) {
+	if bot.zone == nil {
+		location, err := bot.sched.Location()
+		if err != nil {
+			return nil, err
+		}
+		bot.zone = location
+	}
+	return bot.zone, nil
+}
+
+// refresh reads the week of the date, see refreshWeek.
+func (bot *Bot) refresh(date string) error {
+	return refreshWeek(bot.session, bot.sched, date)
+}
+
+// findSlot looks up the slot of the day by its description or by its start,
+// so "18:00" and "6pm" both find "6pm - 7pm".
+func (bot *Bot) findSlot(day string, query string) string {
+	start, clockErr := parseClock(query)
+	for _, table := range bot.sched.RenderSchedule() {
+		for _, tableDay := range table.Days {
+			if tableDay.Day != day {
+				continue
+			}
+			for _, cell := range tableDay.Cells {
+				if strings.EqualFold(cell.Time, query) {
+					return cell.Time
+				}
+				slotStart, _, err := ParseSlotTime(cell.Time)
+				if clockErr == nil && err == nil && slotStart == start {
+					return cell.Time
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func (bot *Bot) findCourt(query string) string {
+	for _, table := range bot.sched.RenderSchedule() {
+		if strings.EqualFold(table.Name, query) {
+			return table.Name
+		}
+	}
+	return ""
+}
+
+func (bot *Bot) show(args []string) (string, error) {
+	day, date := "", ""
+	if len(args) > 0 {
+		var err error
+		day, date, err = bot.parseDay(args[0])
+		if err != nil {
+			return "", err
+		}
+	}
+	err := bot.refresh(date)
+	if err != nil {
+		return "", err
+	}
+	type freeSlot struct {
+		date, day, time string
+		courts          []string
+	}
+	slots := make([]*freeSlot, 0)
+	index := make(map[string]*freeSlot)
+	for _, table := range bot.sched.RenderSchedule() {
+		for _, tableDay := range table.Days {
+			if day != "" && tableDay.Day != day {
+				continue
+			}
+			for _, cell := range tableDay.Cells {
+				if cell.Booked {
+					continue
+				}
+				key := tableDay.Date + " " + cell.Time
+				slot, ok := index[key]
+				if !ok {
+					slot = &freeSlot{date: tableDay.Date, day: tableDay.Day, time: cell.Time}
+					index[key] = slot
+					slots = append(slots, slot)
+				}
+				slot.courts = append(slot.courts, table.Name)
+			}
+		}
+	}
+	if len(slots) == 0 {
+		return "No free slots", nil
+	}
+	sort.SliceStable(slots, func(i, j int) bool {
+		return slots[i].date < slots[j].date
+	})
+	var reply strings.Builder
+	for number, slot := range slots {
+		if number == 0 || slots[number-1].date != slot.date {
+			fmt.Fprintf(&reply, "%s %s\n", slot.day, slot.date)
+		}
+		fmt.Fprintf(&reply, "  %s: %s\n", slot.time, strings.Join(slot.courts, ", "))
+	}
+	return strings.TrimRight(reply.String(), "\n"), nil
+}
+
+func (bot *Bot) book(args []string, from string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("usage: book <day|date> <time> [court]")
+	}
+	day, date, err := bot.parseDay(args[0])
+	if err != nil {
+		return "", err
+	}
+	err = bot.refresh(date)
+	if err != nil {
+		return "", err
+	}
+	// the time may have spaces like "9am - 2pm", so the longest match wins
+	// and the rest of the words are the court
+	slot, court := "", ""
+	rest := args[1:]
+	for count := len(rest); count > 0 && slot == ""; count-- {
+		slot = bot.findSlot(day, strings.Join(rest[:count], " "))
+		if slot != "" && count < len(rest) {
+			court = bot.findCourt(strings.Join(rest[count:], " "))
+			if court == "" {
+				return "", fmt.Errorf("unknown court %q", strings.Join(rest[count:], " "))
+			}
+		}
+	}
+	if slot == "" {
+		return "", fmt.Errorf("no slot %q on %s", strings.Join(rest, " "), day)
+	}
+	courts := bot.Courts
+	if court != "" {
+		courts = []string{court}
+	}
+	description := bot.Description
+	if from != "" {
+		description = fmt.Sprintf("%s (%s)", description, from)
+	}
+	booked := bot.sched.BookPreferredIfPossible(courts, day, slot, description)
+	when := fmt.Sprintf("%s %s %s", day, bot.sched.dateOf(day).Format("2006-01-02"), slot)
+	if booked == nil {
+		return fmt.Sprintf("Sorry, %s is not available", when), nil
+	}
+	if bot.sched.AlreadyHeld() {
+		return fmt.Sprintf("Already booked %s on %s", when, *booked), nil
+	}
+	return fmt.Sprintf("Booked %s on %s", when, *booked), nil
+}
+
+func (bot *Bot) cancel(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("usage: cancel <id>")
+	}
+	bookingID, err := strconv.Atoi(args[0])
+	if err != nil {
+		return "", fmt.Errorf("wrong booking id %q", args[0])
+	}
+	err = bot.session.Authorise()
+	if err != nil {
+		return "", err
+	}
+	err = bot.sched.CancelBooking(bookingID)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Cancelled %d", bookingID), nil
+}
+
+func (bot *Bot) mine() (string, error) {
+	err := bot.refresh("")
+	if err != nil {
+		return "", err
+	}
+	bookings := bot.sched.MyBookings()
+	if len(bookings) == 0 {
+		return "No bookings this week", nil
+	}
+	lines := make([]string, 0, len(bookings))
+	for _, booking := range bookings {
+		lines = append(lines, fmt.Sprintf("%d: %s %s %s on %s", booking.ID, booking.Day, booking.Date, booking.Time, booking.Court))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// StdinAdapter reads a command per line and writes the replies, to try the
+// bot locally.
+type StdinAdapter struct {
+	In     io.Reader
+	Out    io.Writer
+	From   string
+	Prompt string
+}
+
+func (adapter StdinAdapter) Serve(handle func(Message) string) error {
+	scanner := bufio.NewScanner(adapter.In)
+	fmt.Fprint(adapter.Out, adapter.Prompt)
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "quit" || text == "exit" {
+			return nil
+		}
+		if text != "" {
+			fmt.Fprintln(adapter.Out, handle(Message{From: adapter.From, Text: text}))
+		}
+		fmt.Fprint(adapter.Out, adapter.Prompt)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,287 @@
+package lis
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type RecordedRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"`
+}
+
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// Cassette is the recorded traffic with the API in the order of the
+// requests.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cassette := Cassette{}
+	err = json.Unmarshal(data, &cassette)
+	if err != nil {
+		return nil, fmt.Errorf("broken cassette %s: %s", path, err.Error())
+	}
+	return &cassette, nil
+}
+
+func (cassette *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// Recorder is a RoundTripper passing the requests to Transport and writing
+// every request with its response into the cassette file. The credentials
+// never reach the file: cookies and authorization headers, the JSON fields
+// and query parameters named like a password, token or secret, and the
+// Secrets anywhere in the bodies are redacted.
+type Recorder struct {
+	Transport http.RoundTripper
+	Secrets   []string
+	path      string
+	lock      sync.Mutex
+	cassette  Cassette
+}
+
+// NewRecorder starts a new cassette at the path, the transport is
+// http.DefaultTransport if nil.
+func NewRecorder(path string, transport http.RoundTripper) *Recorder {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &Recorder{Transport: transport, path: path}
+}
+
+func (recorder *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var requestBody []byte
+	if req.Body != nil {
+		var err error
+		requestBody, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(requestBody))
+	}
+	resp, err := recorder.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(responseBody))
+
+	interaction := Interaction{
+		Request: RecordedRequest{
+			Method:  req.Method,
+			URL:     redactURL(req.URL),
+			Headers: redactHeaders(req.Header),
+			Body:    redactBody(requestBody, recorder.Secrets),
+		},
+		Response: RecordedResponse{
+			Status:  resp.StatusCode,
+			Headers: redactHeaders(resp.Header),
+			Body:    redactBody(responseBody, recorder.Secrets),
+		},
+	}
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	recorder.cassette.Interactions = append(recorder.cassette.Interactions, interaction)
+	// saved on every request, so the traffic up to a crash is kept too
+	err = recorder.cassette.Save(recorder.path)
+	if err != nil {
+		logAt(LevelWarn, "can not save the cassette", F("path", recorder.path), F("error", err))
+	}
+	return resp, nil
+}
+
+// redactCookies keeps the names of the cookies but not their values. A
+// Set-Cookie has a single cookie followed by its attributes which are kept.
+func redactCookies(header string, single bool) string {
+	parts := strings.Split(header, ";")
+	for index, part := range parts {
+		if single && index > 0 {
+			break
+		}
+		pair := strings.SplitN(part, "=", 2)
+		if len(pair) == 2 {
+			parts[index] = pair[0] + "=" + redacted
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+func redactHeaders(headers http.Header) http.Header {
+	clean := make(http.Header)
+	for name, values := range headers {
+		canonical := http.CanonicalHeaderKey(name)
+		for _, value := range values {
+			switch {
+			case canonical == "Cookie" || canonical == "Set-Cookie":
+				value = redactCookies(value, canonical == "Set-Cookie")
+			case sensitiveKey(canonical):
+				value = redacted
+			}
+			clean[canonical] = append(clean[canonical], value)
+		}
+	}
+	return clean
+}
+
+func redactURL(address *url.URL) string {
+	copied := *address
+	query := copied.Query()
+	for name := range query {
+		if sensitiveKey(name) {
+			query.Set(name, redacted)
+		}
+	}
+	if copied.RawQuery != "" {
+		copied.RawQuery = query.Encode()
+	}
+	copied.User = nil
+	return copied.String()
+}
+
+// redactBody replaces the values of the sensitive fields if the body is JSON
+// and the secrets anywhere in it.
+func redactBody(body []byte, secrets []string) string {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	// the numbers are kept as written, the IDs don't turn into floats
+	decoder.UseNumber()
+	if len(body) > 0 && decoder.Decode(&value) == nil {
+		value = redactJSON(value)
+		clean, err := json.Marshal(value)
+		if err == nil {
+			body = clean
+		}
+	}
+	return redactSecrets(string(body), secrets)
+}
+
+func redactJSON(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, field := range typed {
+			if sensitiveKey(key) {
+				typed[key] = redacted
+			} else {
+				typed[key] = redactJSON(field)
+			}
+		}
+	case []interface{}:
+		for index, item := range typed {
+			typed[index] = redactJSON(item)
+		}
+	}
+	return value
+}
+
+// Replayer is a RoundTripper serving the responses of a cassette instead of
+// the API. A request gets the first unused interaction with the same method,
+// path and query; the last of them once all are used, so polling works.
+type Replayer struct {
+	cassette *Cassette
+	lock     sync.Mutex
+	used     []bool
+}
+
+func NewReplayer(path string) (*Replayer, error) {
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Replayer{cassette: cassette, used: make([]bool, len(cassette.Interactions))}, nil
+}
+
+func (replayer *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	replayer.lock.Lock()
+	defer replayer.lock.Unlock()
+	found := -1
+	for index, interaction := range replayer.cassette.Interactions {
+		if !sameRequest(interaction.Request, req) {
+			continue
+		}
+		found = index
+		if !replayer.used[index] {
+			break
+		}
+	}
+	if found == -1 {
+		return nil, fmt.Errorf("no recorded interaction for %s %s", req.Method, req.URL.RequestURI())
+	}
+	replayer.used[found] = true
+	recorded := replayer.cassette.Interactions[found].Response
+	headers := make(http.Header)
+	for name, values := range recorded.Headers {
+		headers[name] = append([]string(nil), values...)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        headers,
+		Body:          ioutil.NopCloser(strings.NewReader(recorded.Body)),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       req,
+	}, nil
+}
+
+// sameRequest compares the method, path and query, the host differs between
+// the recording and the replay.
+func sameRequest(recorded RecordedRequest, req *http.Request) bool {
+	if recorded.Method != req.Method {
+		return false
+	}
+	address, err := url.Parse(recorded.URL)
+	if err != nil {
+		return false
+	}
+	return address.Path == req.URL.Path && address.Query().Encode() == redactURLQuery(req.URL)
+}
+
+func redactURLQuery(address *url.URL) string {
+	redactedURL, _ := url.Parse(redactURL(address))
+	return redactedURL.Query().Encode()
+}
@@ -0,0 +1,207 @@
+package lis
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultProfileName = "default"
+
+type Profile struct {
+	Endpoint     string   `yaml:"endpoint"`
+	Group        string   `yaml:"group"`
+	Username     string   `yaml:"username"`
+	Password     string   `yaml:"password"`
+	PasswordEnv  string   `yaml:"password_env"`
+	PasswordFile string   `yaml:"password_file"`
+	PasswordCmd  string   `yaml:"password_cmd"`
+	Courts       []string `yaml:"courts"`
+	// FallbackAnyCourt lets any court be booked when none of the courts is
+	// free.
+	FallbackAnyCourt bool            `yaml:"fallback_any_court"`
+	Description      string          `yaml:"description"`
+	Webhooks         []WebhookConfig `yaml:"webhooks"`
+	SMTP             *SMTPConfig     `yaml:"smtp"`
+	Rules            *Rules          `yaml:"rules"`
+}
+
+// WebhookConfig describes a webhook of the profile. The secret may be taken
+// from the environment variable named by secret_env. No events means all.
+type WebhookConfig struct {
+	URL       string   `yaml:"url"`
+	Secret    string   `yaml:"secret"`
+	SecretEnv string   `yaml:"secret_env"`
+	Events    []string `yaml:"events"`
+}
+
+type ConfigFile struct {
+	DefaultProfile string             `yaml:"default_profile"`
+	Profiles       map[string]Profile `yaml:"profiles"`
+}
+
+// ConfigPath returns the location of the config file inside the XDG config
+// dir, i.e. $XDG_CONFIG_HOME/lis/config.yaml or ~/.config/lis/config.yaml.
+func ConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "lis", "config.yaml")
+}
+
+// LoadConfigFile reads the config file. A missing file is not an error, it
+// just has no profiles. A file with plain passwords or secrets must not be
+// accessible by the group or others, like a password file.
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	config := ConfigFile{}
+	if path == "" {
+		return &config, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("can not parse %s: %s", path, err.Error())
+	}
+	if config.hasSecrets() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode().Perm()&0077 != 0 {
+			return nil, fmt.Errorf("permissions %04o for %s are too open, it should be 0600 since it has plain passwords or secrets", info.Mode().Perm(), path)
+		}
+	}
+	return &config, nil
+}
+
+// hasSecrets tells whether a profile keeps a password or a secret in the
+// file itself instead of taking it from elsewhere.
+func (config *ConfigFile) hasSecrets() bool {
+	for _, profile := range config.Profiles {
+		if profile.Password != "" || profile.SMTP != nil && profile.SMTP.Password != "" {
+			return true
+		}
+		for _, webhook := range profile.Webhooks {
+			if webhook.Secret != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Profile looks up the named profile. An empty name selects default_profile
+// of the file or the profile called "default"; it's fine for that one to be
+// absent, but an explicitly requested profile must exist.
+func (config *ConfigFile) Profile(name string) (Profile, error) {
+	explicit := name != ""
+	if !explicit {
+		name = config.DefaultProfile
+	}
+	if name == "" {
+		name = defaultProfileName
+	}
+	profile, ok := config.Profiles[name]
+	if !ok && (explicit || config.DefaultProfile != "") {
+		return Profile{}, fmt.Errorf("profile %s is not found", name)
+	}
+	return profile, nil
+}
+
+// ApplyEnv overrides the profile with LIS_ENDPOINT, LIS_GROUP, LIS_USERNAME,
+// LIS_PASSWORD, LIS_COURTS (comma separated) and LIS_DESCRIPTION.
+// LIS_WEBHOOK_URL with LIS_WEBHOOK_SECRET adds one more webhook.
+func (profile *Profile) ApplyEnv() {
+	envs := map[string]*string{
+		"LIS_ENDPOINT":    &profile.Endpoint,
+		"LIS_GROUP":       &profile.Group,
+		"LIS_USERNAME":    &profile.Username,
+		"LIS_PASSWORD":    &profile.Password,
+		"LIS_DESCRIPTION": &profile.Description,
+	}
+	for name, field := range envs {
+		value, ok := os.LookupEnv(name)
+		if ok {
+			*field = value
+		}
+	}
+	courts, ok := os.LookupEnv("LIS_COURTS")
+	if ok {
+		profile.Courts = make([]string, 0)
+		for _, court := range strings.Split(courts, ",") {
+			court = strings.TrimSpace(court)
+			if court != "" {
+				profile.Courts = append(profile.Courts, court)
+			}
+		}
+	}
+	webhook, ok := os.LookupEnv("LIS_WEBHOOK_URL")
+	if ok && webhook != "" {
+		profile.Webhooks = append(profile.Webhooks, WebhookConfig{URL: webhook, SecretEnv: "LIS_WEBHOOK_SECRET"})
+	}
+}
+
+// Notifier makes the notifiers of the webhooks of the profile, nil if there
+// are none.
+func (profile *Profile) Notifier() Notifier {
+	if len(profile.Webhooks) == 0 {
+		return nil
+	}
+	notifiers := make(Notifiers, 0, len(profile.Webhooks))
+	for _, config := range profile.Webhooks {
+		webhook := NewWebhook(config.URL, config.Secret)
+		if config.SecretEnv != "" {
+			webhook.Secret = os.Getenv(config.SecretEnv)
+		}
+		webhook.Events = config.Events
+		notifiers = append(notifiers, webhook)
+	}
+	return notifiers
+}
+
+// Credentials picks the password source of the profile: a plain password,
+// then password_env, password_file and password_cmd. Nil means none is set.
+func (profile *Profile) Credentials() CredentialProvider {
+	switch {
+	case profile.Password != "":
+		return StaticPassword(profile.Password)
+	case profile.PasswordEnv != "":
+		return EnvPassword{Name: profile.PasswordEnv}
+	case profile.PasswordFile != "":
+		return FilePassword{Path: profile.PasswordFile}
+	case profile.PasswordCmd != "":
+		return CommandPassword{Command: profile.PasswordCmd}
+	}
+	return nil
+}
+
+func LoadProfile(path string, name string) (*Profile, error) {
+	config, err := LoadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := config.Profile(name)
+	if err != nil {
+		return nil, err
+	}
+	profile.ApplyEnv()
+	if profile.Rules != nil {
+		err = profile.Rules.Prepare()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &profile, nil
+}
@@ -0,0 +1,136 @@
+package lis
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// CredentialProvider supplies the password at the moment it is needed, so
+// it doesn't have to be passed around as plain text.
+type CredentialProvider interface {
+	Password() (string, error)
+}
+
+type StaticPassword string
+
+func (password StaticPassword) Password() (string, error) {
+	return string(password), nil
+}
+
+type EnvPassword struct {
+	Name string
+}
+
+func (provider EnvPassword) Password() (string, error) {
+	password, ok := os.LookupEnv(provider.Name)
+	if !ok || password == "" {
+		return "", fmt.Errorf("environment variable %s is not set", provider.Name)
+	}
+	return password, nil
+}
+
+// FilePassword reads the first line of the file. The file must not be
+// accessible by the group or others, like ssh does for the private keys.
+type FilePassword struct {
+	Path string
+}
+
+func (provider FilePassword) Password() (string, error) {
+	info, err := os.Stat(provider.Path)
+	if err != nil {
+		return "", err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("permissions %04o for %s are too open, it should be 0600", info.Mode().Perm(), provider.Path)
+	}
+	data, err := ioutil.ReadFile(provider.Path)
+	if err != nil {
+		return "", err
+	}
+	password := strings.SplitN(string(data), "\n", 2)[0]
+	password = strings.TrimSuffix(password, "\r")
+	if password == "" {
+		return "", fmt.Errorf("password file %s is empty", provider.Path)
+	}
+	return password, nil
+}
+
+// PromptPassword asks for the password on the terminal without echo. When
+// the input is not a terminal the first line of it is used.
+type PromptPassword struct {
+	Prompt string
+	In     *os.File
+}
+
+func (provider PromptPassword) Password() (string, error) {
+	in := provider.In
+	if in == nil {
+		in = os.Stdin
+	}
+	if term.IsTerminal(int(in.Fd())) {
+		fmt.Fprint(os.Stderr, provider.Prompt)
+		password, err := term.ReadPassword(int(in.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		return string(password), nil
+	}
+	line, err := readLine(in)
+	if err != nil && line == "" {
+		return "", errors.New("no password on the input")
+	}
+	return strings.TrimRight(line, "\r"), nil
+}
+
+// readLine reads the input byte by byte up to the end of the line, so the
+// rest of it is left to the next reader, e.g. the commands of the bot.
+func readLine(in io.Reader) (string, error) {
+	var line strings.Builder
+	buffer := make([]byte, 1)
+	for {
+		_, err := io.ReadFull(in, buffer)
+		if err != nil {
+			return line.String(), err
+		}
+		if buffer[0] == '\n' {
+			return line.String(), nil
+		}
+		line.WriteByte(buffer[0])
+	}
+}
+
+// CommandPassword runs the command through the shell and takes the first
+// line of its output, e.g. "pass show squash".
+type CommandPassword struct {
+	Command string
+}
+
+func (provider CommandPassword) Password() (string, error) {
+	cmd := exec.Command("sh", "-c", provider.Command)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("password command failed: %s", err.Error())
+	}
+	password := strings.TrimRight(strings.SplitN(string(output), "\n", 2)[0], "\r")
+	if password == "" {
+		return "", errors.New("password command returned nothing")
+	}
+	return password, nil
+}
+
+func NewInstanceWithCredentials(endpoint string, username string, credentials CredentialProvider, groupname string, options ...Option) (*Client, error) {
+	password, err := credentials.Password()
+	if err != nil {
+		return nil, err
+	}
+	return NewInstance(endpoint, username, password, groupname, options...), nil
+}
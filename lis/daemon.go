@@ -0,0 +1,509 @@
+package lis
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Job books the slot on every date of its recurrence, Day is a shorthand for
+// a single weekday. The slot is released DaysBefore days earlier at the
+// Release time of the group timezone.
+type Job struct {
+	Recurrence `yaml:",inline"`
+
+	Name        string   `yaml:"name"`
+	Day         string   `yaml:"day"`
+	Time        string   `yaml:"time"`
+	Courts      []string `yaml:"courts"`
+	Description string   `yaml:"description"`
+	For         string   `yaml:"for"`
+	Release     string   `yaml:"release"`
+	DaysBefore  int      `yaml:"days_before"`
+	Retry       string   `yaml:"retry"`
+	Attempts    int      `yaml:"attempts"`
+
+	release time.Time
+	retry   time.Duration
+}
+
+type JobsFile struct {
+	Jobs []Job `yaml:"jobs"`
+}
+
+type JobOutcome struct {
+	Job     string    `json:"job" yaml:"job"`
+	Date    string    `json:"date" yaml:"date"`
+	Day     string    `json:"day" yaml:"day"`
+	Time    string    `json:"time" yaml:"time"`
+	Court   string    `json:"court" yaml:"court"`
+	Booked  bool      `json:"booked" yaml:"booked"`
+	Error   string    `json:"error,omitempty" yaml:"error,omitempty"`
+	Started time.Time `json:"started" yaml:"started"`
+	Elapsed string    `json:"elapsed" yaml:"elapsed"`
+	DryRun  bool      `json:"dry_run,omitempty" yaml:"dry_run,omitempty"`
+	// For is the username of the teammate the job books for
+	For string `json:"for,omitempty" yaml:"for,omitempty"`
+	// AlreadyHeld means the slot was mine before the job, it's not booked,
+	// recorded or notified again
+	AlreadyHeld bool `json:"already_held,omitempty" yaml:"already_held,omitempty"`
+}
+
+func JobsPath() string {
+	config := ConfigPath()
+	if config == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(config), "jobs.yaml")
+}
+
+func LoadJobs(path string) ([]Job, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var jobsFile JobsFile
+	err = yaml.Unmarshal(data, &jobsFile)
+	if err != nil {
+		return nil, fmt.Errorf("can not parse %s: %s", path, err.Error())
+	}
+	for index := range jobsFile.Jobs {
+		err = jobsFile.Jobs[index].Prepare()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return jobsFile.Jobs, nil
+}
+
+// Prepare validates the job and fills the defaults.
+func (job *Job) Prepare() error {
+	if job.Day != "" {
+		job.Days = append([]string{job.Day}, job.Days...)
+		job.Day = ""
+	}
+	if job.Name == "" {
+		job.Name = fmt.Sprintf("%s %s", strings.Join(job.Days, ","), job.Time)
+	}
+	err := job.Recurrence.Prepare()
+	if err != nil {
+		return fmt.Errorf("job %s: %s", job.Name, err.Error())
+	}
+	if job.Time == "" {
+		return fmt.Errorf("job %s: time is not set", job.Name)
+	}
+	if job.Release == "" {
+		job.Release = "00:00"
+	}
+	release, err := time.Parse("15:04", job.Release)
+	if err != nil {
+		return fmt.Errorf("job %s: release should be HH:MM", job.Name)
+	}
+	job.release = release
+	if job.DaysBefore < 0 {
+		return fmt.Errorf("job %s: days_before can not be negative", job.Name)
+	}
+	if job.Retry == "" {
+		job.Retry = "500ms"
+	}
+	job.retry, err = time.ParseDuration(job.Retry)
+	if err != nil {
+		return fmt.Errorf("job %s: %s", job.Name, err.Error())
+	}
+	if job.Attempts <= 0 {
+		job.Attempts = 10
+	}
+	return nil
+}
+
+// ReleaseOf returns the moment when the slot of the game date is released.
+func (job *Job) ReleaseOf(game time.Time) time.Time {
+	releaseDay := game.AddDate(0, 0, -job.DaysBefore)
+	return time.Date(releaseDay.Year(), releaseDay.Month(), releaseDay.Day(), job.release.Hour(), job.release.Minute(), 0, 0, game.Location())
+}
+
+// NextRun returns the first release moment after now and the date of the
+// game it opens, both in the given location. False means the recurrence of
+// the job is over.
+func (job *Job) NextRun(now time.Time, location *time.Location) (time.Time, time.Time, bool) {
+	now = now.In(location)
+	game, ok := job.NextDate(now)
+	for ok {
+		release := job.ReleaseOf(game)
+		if release.After(now) {
+			return release, game, true
+		}
+		game, ok = job.NextDate(game.AddDate(0, 0, 1))
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// keptOutcomes is how many of the last outcomes the daemon keeps.
+const keptOutcomes = 100
+
+type Daemon struct {
+	session  *Client
+	jobs     []Job
+	wake     time.Duration
+	location *time.Location
+	stop     <-chan struct{}
+	// Outcomes are the last outcomes of the jobs run, the older ones are
+	// dropped.
+	Outcomes []JobOutcome
+	Report   func(JobOutcome)
+	History  *History
+	// Poll is the pause between the checks of the slots of the upcoming
+	// games for cancellations, zero disables the checks.
+	Poll time.Duration
+	// Since is the moment Run looks for the releases after, now if zero. A
+	// restarted daemon may set it to when it stopped to run the missed ones.
+	Since time.Time
+	// DryRun makes the jobs resolve their slots without booking them.
+	DryRun bool
+	// Ledger and AnyCourtGuard are passed to the schedules of the jobs, see
+	// Schedule.SetLedger and Schedule.SetDuplicateGuard.
+	Ledger        *BookingLedger
+	AnyCourtGuard bool
+	// Rules are the club policy the jobs keep to, see Schedule.SetRules.
+	Rules    *Rules
+	statuses map[string]string
+}
+
+// NewDaemon prepares the daemon for the authorised session. The jobs are run
+// in the timezone of the group, or the local one if the group can't be read.
+// A job booking for a user who is not found, or for others without the
+// permission, fails it before any release.
+func NewDaemon(session *Client, jobs []Job, wake time.Duration) (*Daemon, error) {
+	sched, err := NewSchedule(session)
+	if err != nil {
+		return nil, err
+	}
+	location, err := sched.Location()
+	if err != nil {
+		return nil, err
+	}
+	err = checkBookers(sched, jobs)
+	if err != nil {
+		return nil, err
+	}
+	return &Daemon{
+		session:  session,
+		jobs:     jobs,
+		wake:     wake,
+		location: location,
+		Outcomes: make([]JobOutcome, 0),
+		statuses: make(map[string]string),
+	}, nil
+}
+
+// checkBookers resolves the users the jobs book for, the users are read once.
+func checkBookers(sched *Schedule, jobs []Job) error {
+	for _, job := range jobs {
+		if job.For == "" {
+			continue
+		}
+		if sched.users == nil {
+			users, err := sched.getUsers()
+			if err != nil {
+				return fmt.Errorf("can not read the users: %s", err.Error())
+			}
+			sched.users = users
+		}
+		sched.SetBookFor(job.For)
+		_, err := sched.Booker()
+		if err != nil {
+			return fmt.Errorf("job %s: %s", job.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+func (daemon *Daemon) Location() *time.Location {
+	return daemon.location
+}
+
+// Run executes the jobs in order of their release until stop is closed. The
+// jobs sharing a release run together, and a release passed while the jobs
+// before it ran is run late rather than skipped.
+func (daemon *Daemon) Run(stop <-chan struct{}) {
+	if len(daemon.jobs) == 0 {
+		daemon.session.log(LevelInfo, "no jobs to run")
+		return
+	}
+	daemon.stop = stop
+	since := daemon.Since
+	if since.IsZero() {
+		since = time.Now()
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		type dueJob struct {
+			job  Job
+			game time.Time
+		}
+		due := make([]dueJob, 0)
+		var nextRelease time.Time
+		for index := range daemon.jobs {
+			release, game, ok := daemon.jobs[index].NextRun(since, daemon.location)
+			if !ok {
+				continue
+			}
+			if len(due) == 0 || release.Before(nextRelease) {
+				due, nextRelease = due[:0], release
+			}
+			if release.Equal(nextRelease) {
+				due = append(due, dueJob{daemon.jobs[index], game})
+			}
+		}
+		if len(due) == 0 {
+			daemon.session.log(LevelInfo, "all the jobs are over")
+			return
+		}
+		for _, next := range due {
+			daemon.session.log(LevelInfo, "next job", F("job", next.job.Name), F("release", nextRelease.Format(time.RFC3339)), F("date", next.game.Format("2006-01-02")))
+		}
+
+		// the check is skipped when the job wakes up within a poll interval
+		// after it, so a slow check can't delay the job
+		var poll <-chan time.Time
+		if daemon.Poll > 0 && time.Until(nextRelease.Add(-daemon.wake)) > 2*daemon.Poll {
+			poll = time.After(daemon.Poll)
+		}
+		select {
+		case <-stop:
+			return
+		case <-poll:
+			daemon.CheckFreed()
+			continue
+		case <-time.After(time.Until(nextRelease.Add(-daemon.wake))):
+		}
+		outcomes := make([]JobOutcome, len(due))
+		var running sync.WaitGroup
+		for index, next := range due {
+			running.Add(1)
+			go func(index int, next dueJob) {
+				defer running.Done()
+				outcomes[index] = daemon.RunJob(next.job, nextRelease, next.game)
+			}(index, next)
+		}
+		running.Wait()
+		since = nextRelease
+		for _, outcome := range outcomes {
+			daemon.Outcomes = append(daemon.Outcomes, outcome)
+			if len(daemon.Outcomes) > keptOutcomes {
+				daemon.Outcomes = daemon.Outcomes[len(daemon.Outcomes)-keptOutcomes:]
+			}
+			if daemon.Report != nil {
+				daemon.Report(outcome)
+			}
+		}
+	}
+}
+
+// wait sleeps until the moment, false if the daemon is stopped before.
+func (daemon *Daemon) wait(until time.Time) bool {
+	timer := time.NewTimer(time.Until(until))
+	defer timer.Stop()
+	select {
+	case <-daemon.stop:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// RunJob authorises and warms the schedule up before the release, then tries
+// to book the game until the attempts are over or the daemon is stopped. The
+// job works on its own copy of the session, so the week it switches to is
+// not seen by the others.
+func (daemon *Daemon) RunJob(job Job, release time.Time, game time.Time) JobOutcome {
+	started := time.Now()
+	outcome := JobOutcome{
+		Job:     job.Name,
+		Date:    game.Format("2006-01-02"),
+		Day:     dayNames[game.Weekday()],
+		Time:    job.Time,
+		Started: started,
+		DryRun:  daemon.DryRun,
+	}
+	defer func() {
+		if !daemon.DryRun && !outcome.AlreadyHeld {
+			daemon.session.notify(outcomeEvent(outcome))
+		}
+	}()
+	err := daemon.session.Authorise()
+	if err != nil {
+		outcome.Error = err.Error()
+		outcome.Elapsed = time.Since(started).String()
+		return outcome
+	}
+	session := daemon.session.fork()
+	sched, err := NewSchedule(session)
+	if err != nil {
+		outcome.Error = err.Error()
+		outcome.Elapsed = time.Since(started).String()
+		return outcome
+	}
+	sched.SetHistory(daemon.History, "daemon:"+job.Name)
+	sched.SetDryRun(daemon.DryRun)
+	sched.SetLedger(daemon.Ledger)
+	sched.SetDuplicateGuard(daemon.AnyCourtGuard)
+	sched.SetRules(daemon.Rules)
+	sched.SetBookFor(job.For)
+	session.SetFaketime(outcome.Date)
+	err = sched.Refresh()
+	if err != nil {
+		// not fatal, the attempts refresh the schedule anyway
+		daemon.session.log(LevelWarn, "job failed to warm up", F("job", job.Name), F("error", err))
+	}
+	outcome.For = sched.bookerName()
+
+	if !daemon.wait(release) {
+		outcome.Error = "daemon is stopped"
+		outcome.Elapsed = time.Since(started).String()
+		return outcome
+	}
+	// only the outcome of the attempts is recorded
+	var last *Attempt
+	for attempt := 1; attempt <= job.Attempts; attempt++ {
+		booked, try, result := sched.tryOnDate(game, job.Courts, job.Time, job.Description)
+		if result == held {
+			outcome.AlreadyHeld = true
+			outcome.Court = *booked
+			break
+		}
+		if result == tried {
+			last = &try
+		}
+		if booked != nil {
+			if !daemon.DryRun {
+				observeSince(metrics.releaseLatency, release)
+			}
+			outcome.Booked = true
+			outcome.Court = *booked
+			break
+		}
+		daemon.session.log(LevelWarn, "job attempt failed", F("job", job.Name), F("attempt", attempt), F("attempts", job.Attempts))
+		if attempt < job.Attempts && !daemon.wait(time.Now().Add(job.retry)) {
+			outcome.Error = "daemon is stopped"
+			break
+		}
+	}
+	if !outcome.Booked && !outcome.AlreadyHeld && outcome.Error == "" {
+		outcome.Error = "slot is not available"
+	}
+	if last != nil && !outcome.AlreadyHeld {
+		sched.record(*last)
+	}
+	outcome.Elapsed = time.Since(started).String()
+	return outcome
+}
+
+type PlannedGame struct {
+	Job     string    `json:"job" yaml:"job"`
+	Date    string    `json:"date" yaml:"date"`
+	Day     string    `json:"day" yaml:"day"`
+	Time    string    `json:"time" yaml:"time"`
+	Release time.Time `json:"release" yaml:"release"`
+	Status  string    `json:"status" yaml:"status"`
+}
+
+// CheckFreed reads the slots of the next two games of every job and sends
+// slot.freed for those which were booked at the previous check and are free
+// now, or booking.cancelled if it was my booking which is gone. The sent
+// events are returned.
+func (daemon *Daemon) CheckFreed() []Event {
+	freed := make([]Event, 0)
+	sched, err := NewSchedule(daemon.session.fork())
+	if err != nil {
+		return freed
+	}
+	err = daemon.session.Authorise()
+	if err != nil {
+		daemon.session.log(LevelWarn, "can not check the slots", F("error", err))
+		return freed
+	}
+	now := time.Now().In(daemon.location)
+	for _, job := range daemon.jobs {
+		for _, game := range job.Occurrences(now, 2) {
+			key := fmt.Sprintf("%s %s", job.Name, game.Format("2006-01-02"))
+			status := sched.SlotStatus(game, job.Courts, job.Time)
+			if status == "unknown" {
+				continue
+			}
+			previous := daemon.statuses[key]
+			daemon.statuses[key] = status
+			eventType := ""
+			if previous == "mine" && status != "mine" {
+				eventType = EventBookingCancelled
+			} else if previous == "booked" && status == "free" {
+				eventType = EventSlotFreed
+			}
+			if eventType == "" {
+				continue
+			}
+			event := Event{
+				Type: eventType,
+				Job:  job.Name,
+				Date: game.Format("2006-01-02"),
+				Day:  dayNames[game.Weekday()],
+				Time: job.Time,
+			}
+			daemon.session.log(LevelInfo, "slot changed", F("job", job.Name), F("date", event.Date), F("time", event.Time), F("event", event.Type))
+			daemon.session.notify(event)
+			freed = append(freed, event)
+		}
+	}
+	return freed
+}
+
+// Plan lists the next count games of every job starting from today with the
+// current status of their slots.
+func (daemon *Daemon) Plan(count int) []PlannedGame {
+	sched, err := NewSchedule(daemon.session.fork())
+	if err != nil {
+		return nil
+	}
+	plan := make([]PlannedGame, 0)
+	now := time.Now().In(daemon.location)
+	for _, job := range daemon.jobs {
+		for _, game := range job.Occurrences(now, count) {
+			plan = append(plan, PlannedGame{
+				Job:     job.Name,
+				Date:    game.Format("2006-01-02"),
+				Day:     dayNames[game.Weekday()],
+				Time:    job.Time,
+				Release: job.ReleaseOf(game),
+				Status:  sched.SlotStatus(game, job.Courts, job.Time),
+			})
+		}
+	}
+	sort.SliceStable(plan, func(i, j int) bool {
+		return plan[i].Date < plan[j].Date
+	})
+	return plan
+}
+
+// DryRunJobs runs every job for its next game right away in the dry run, so
+// the jobs can be checked without waiting for the release or booking.
+func (daemon *Daemon) DryRunJobs() []JobOutcome {
+	daemon.DryRun = true
+	outcomes := make([]JobOutcome, 0, len(daemon.jobs))
+	now := time.Now().In(daemon.location)
+	for _, job := range daemon.jobs {
+		for _, game := range job.Occurrences(now, 1) {
+			outcomes = append(outcomes, daemon.RunJob(job, time.Now(), game))
+		}
+	}
+	return outcomes
+}
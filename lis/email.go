@@ -0,0 +1,140 @@
+package lis
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SMTPConfig struct {
+	Host        string   `yaml:"host"`
+	Port        int      `yaml:"port"`
+	Username    string   `yaml:"username"`
+	Password    string   `yaml:"password"`
+	PasswordEnv string   `yaml:"password_env"`
+	From        string   `yaml:"from"`
+	Partners    []string `yaml:"partners"`
+}
+
+// EmailNotifier emails the booker about the events of the bookings. The
+// user gets no emails at all without EmailPreferences and no emails about
+// the changes of the bookings without BookingChangeEmails. The teammate a
+// booking is made for gets its emails by the same preferences, if the
+// teammate is among the users set with SetUsers. The partners get the
+// successful bookings only.
+type EmailNotifier struct {
+	config SMTPConfig
+	user   User
+	users  []User
+}
+
+func NewEmailNotifier(config SMTPConfig, user User) *EmailNotifier {
+	if config.Port == 0 {
+		config.Port = 587
+	}
+	if config.PasswordEnv != "" {
+		config.Password = os.Getenv(config.PasswordEnv)
+	}
+	return &EmailNotifier{
+		config: config,
+		user:   user,
+	}
+}
+
+// SetUsers gives the users of the club to find the teammates in.
+func (notifier *EmailNotifier) SetUsers(users []User) {
+	notifier.users = users
+}
+
+// emailOf returns the address of the user if the user wants the emails of
+// the event type.
+func emailOf(user User, eventType string) []string {
+	if !user.EmailPreferences || user.Email == "" {
+		return nil
+	}
+	switch eventType {
+	case EventBookingSucceeded, EventBookingFailed:
+		return []string{user.Email}
+	case EventBookingCancelled, EventSlotFreed:
+		if user.BookingChangeEmails {
+			return []string{user.Email}
+		}
+	}
+	return nil
+}
+
+func (notifier *EmailNotifier) recipients(event Event) []string {
+	to := emailOf(notifier.user, event.Type)
+	for _, user := range notifier.users {
+		if event.For != "" && strings.EqualFold(user.Username, event.For) && user.ID != notifier.user.ID {
+			to = append(to, emailOf(user, event.Type)...)
+		}
+	}
+	if event.Type == EventBookingSucceeded {
+		to = append(to, notifier.config.Partners...)
+	}
+	return to
+}
+
+func emailSubject(event Event) string {
+	slot := strings.TrimSpace(fmt.Sprintf("%s %s %s", event.Day, event.Date, event.Time))
+	switch event.Type {
+	case EventBookingSucceeded:
+		return fmt.Sprintf("Booked %s on %s", slot, event.Court)
+	case EventBookingFailed:
+		return fmt.Sprintf("Failed to book %s", slot)
+	case EventBookingCancelled:
+		return fmt.Sprintf("Booking of %s is cancelled", slot)
+	case EventSlotFreed:
+		return fmt.Sprintf("%s is free again", slot)
+	}
+	return event.Type
+}
+
+func (notifier *EmailNotifier) message(to []string, event Event) []byte {
+	subject := emailSubject(event)
+	lines := []string{
+		"From: " + notifier.config.From,
+		"To: " + strings.Join(to, ", "),
+		"Subject: " + subject,
+		"Date: " + event.At.Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		subject + ".",
+	}
+	if event.For != "" {
+		lines = append(lines, "For: "+event.For)
+	}
+	if event.Job != "" {
+		lines = append(lines, "Job: "+event.Job)
+	}
+	if event.Error != "" {
+		lines = append(lines, "Reason: "+event.Error)
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+func (notifier *EmailNotifier) Notify(event Event) error {
+	to := notifier.recipients(event)
+	if len(to) == 0 {
+		return nil
+	}
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+	var auth smtp.Auth
+	if notifier.config.Username != "" {
+		auth = smtp.PlainAuth("", notifier.config.Username, notifier.config.Password, notifier.config.Host)
+	}
+	address := net.JoinHostPort(notifier.config.Host, strconv.Itoa(notifier.config.Port))
+	err := smtp.SendMail(address, auth, notifier.config.From, to, notifier.message(to, event))
+	if err != nil {
+		return fmt.Errorf("email to %s: %s", strings.Join(to, ", "), err.Error())
+	}
+	return nil
+}
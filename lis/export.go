@@ -0,0 +1,140 @@
+package lis
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var exportFormats = []string{"csv", "tsv"}
+
+var exportData = []string{"schedule", "bookings", "mine", "history"}
+
+// newTableWriter writes comma separated values for csv and tab separated
+// ones for tsv, so the result can be pasted into a spreadsheet as is.
+func newTableWriter(w io.Writer, format string) (*csv.Writer, error) {
+	writer := csv.NewWriter(w)
+	switch format {
+	case "csv":
+	case "tsv":
+		writer.Comma = '\t'
+	default:
+		return nil, fmt.Errorf("unknown export format %s", format)
+	}
+	return writer, nil
+}
+
+func writeTable(w io.Writer, format string, header []string, rows [][]string) error {
+	writer, err := newTableWriter(w, format)
+	if err != nil {
+		return err
+	}
+	err = writer.Write(header)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		for index := range row {
+			row[index] = escapeFormula(row[index])
+		}
+	}
+	err = writer.WriteAll(rows)
+	if err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+// escapeFormula prefixes the value with a quote if it starts like a formula,
+// so a description like "=HYPERLINK(...)" stays text in the spreadsheet.
+func escapeFormula(value string) string {
+	if value != "" && strings.ContainsAny(value[:1], "=+-@") {
+		return "'" + value
+	}
+	return value
+}
+
+// WriteScheduleTable writes a row per date, court and slot of the time
+// tables, ordered by date first, so the usage of a day stays together.
+func WriteScheduleTable(w io.Writer, format string, tables []TimeTable) error {
+	rows := make([][]string, 0)
+	for _, table := range tables {
+		for _, day := range table.Days {
+			for _, cell := range day.Cells {
+				status := "free"
+				if cell.Mine {
+					status = "mine"
+				} else if cell.Booked {
+					status = "booked"
+				}
+				rows = append(rows, []string{
+					day.Date,
+					day.Day,
+					table.Name,
+					cell.Time,
+					status,
+					cell.Booker,
+					cell.Description,
+				})
+			}
+		}
+	}
+	sortByDate(rows)
+	return writeTable(w, format, []string{"date", "day", "court", "time", "status", "booker", "description"}, rows)
+}
+
+func WriteBookingsTable(w io.Writer, format string, bookings []BookingDetails) error {
+	rows := make([][]string, 0, len(bookings))
+	for _, booking := range bookings {
+		rows = append(rows, []string{
+			booking.Date,
+			booking.Day,
+			booking.Court,
+			booking.Time,
+			strconv.Itoa(booking.ID),
+			booking.Booker,
+			booking.Description,
+		})
+	}
+	sortByDate(rows)
+	return writeTable(w, format, []string{"date", "day", "court", "time", "booking_id", "booker", "description"}, rows)
+}
+
+func WriteHistoryTable(w io.Writer, format string, attempts []Attempt) error {
+	rows := make([][]string, 0, len(attempts))
+	for _, attempt := range attempts {
+		total := 0.0
+		for _, latency := range attempt.LatencyMS {
+			total += latency
+		}
+		bookingID := ""
+		if attempt.BookingID != 0 {
+			bookingID = strconv.Itoa(attempt.BookingID)
+		}
+		rows = append(rows, []string{
+			attempt.AttemptedAt.Format(time.RFC3339),
+			attempt.Source,
+			attempt.Date,
+			attempt.Day,
+			attempt.Court,
+			attempt.Time,
+			strconv.FormatBool(attempt.Booked),
+			bookingID,
+			strconv.FormatFloat(total, 'f', 1, 64),
+			attempt.Failure,
+		})
+	}
+	return writeTable(w, format, []string{"attempted_at", "source", "date", "day", "court", "time", "booked", "booking_id", "latency_ms", "failure"}, rows)
+}
+
+// sortByDate orders the rows by their first column keeping the order of the
+// rows of the same date.
+func sortByDate(rows [][]string) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i][0] < rows[j][0]
+	})
+}
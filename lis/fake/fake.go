@@ -0,0 +1,480 @@
+// Package fake is an in-memory booking server speaking the e-allocator API
+// used by lis, for the tests of lis and of the tools built on it. Unlike a
+// recorded fixture it keeps state: the bookings made through it show up in
+// the weekly schedule, a taken slot is refused and a cancelled one is free
+// again.
+package fake
+
+import (
+	"LIS/lis"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Failure makes the server answer Times matching requests with Status
+// instead of serving them, forever if Times is 0. An empty Method matches
+// any method, Path is a prefix of the path, e.g. "/bookings".
+type Failure struct {
+	Method string
+	Path   string
+	Status int
+	Times  int
+}
+
+type Server struct {
+	lock            sync.Mutex
+	group           lis.Group
+	users           []lis.User
+	passwords       map[string]string
+	resources       []lis.Resource
+	timeSlots       []lis.TimeSlot
+	bookedTimeSlots []lis.BookedTimeSlot
+	bookings        []lis.Boooking
+	sessions        map[string]uint64
+	failures        []Failure
+	requests        []string
+	nextID          int
+	latency         time.Duration
+}
+
+// New returns a club of the group TEST (ID 1234, UTC) with the users TEST
+// (ID 123), DEMO (ID 360847) and ADMIN (ID 359235) whose passwords are their
+// usernames, the courts Cessna 172 and Piper Archer, and two slots a day,
+// "9am - 2pm" and the prime "2pm - 7pm".
+func New() *Server {
+	server := &Server{
+		group:     lis.Group{ID: 1234, Groupname: "TEST", Description: "Demo Squash Club", FirstDayOfWeek: 2, Timezone: "UTC"},
+		passwords: make(map[string]string),
+		sessions:  make(map[string]uint64),
+		nextID:    1,
+	}
+	server.AddUser(lis.User{ID: 123, Username: "TEST", Name: "Test Player", Email: "test@e-allocator.com", AllowEdits: true, EmailPreferences: true}, "TEST")
+	server.AddUser(lis.User{ID: 360847, Username: "DEMO", Name: "Demo User", Email: "demo@e-allocator.com", AllowEdits: true, AllowAlterOthers: true, EmailPreferences: true}, "DEMO")
+	server.AddUser(lis.User{ID: 359235, Username: "ADMIN", Name: "Demo Administrator", Email: "admin@e-allocator.com", Administrator: true, AllowEdits: true, AllowAlterOthers: true}, "ADMIN")
+	server.AddResource(lis.Resource{ID: 77787, Description: "Cessna 172", PrimaryFlag: true, SequenceNum: 1})
+	server.AddResource(lis.Resource{ID: 77791, Description: "Piper Archer", PrimaryFlag: true, SequenceNum: 2})
+	for day := 1; day <= 7; day++ {
+		server.AddTimeSlot(lis.TimeSlot{ID: 759158 + 2*day, DayOfWeek: day, Description: "9am - 2pm", SequenceNum: 2*day - 1})
+		server.AddTimeSlot(lis.TimeSlot{ID: 759159 + 2*day, DayOfWeek: day, Description: "2pm - 7pm", SequenceNum: 2 * day, Prime: true})
+	}
+	return server
+}
+
+func (server *Server) Group() lis.Group {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return server.group
+}
+
+func (server *Server) SetGroup(group lis.Group) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.group = group
+}
+
+// AddUser adds the member of the group or replaces the one with the same ID.
+func (server *Server) AddUser(user lis.User, password string) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	user.GroupID = server.group.ID
+	server.passwords[user.Username] = password
+	for index := range server.users {
+		if server.users[index].ID == user.ID {
+			server.users[index] = user
+			return
+		}
+	}
+	server.users = append(server.users, user)
+}
+
+func (server *Server) AddResource(resource lis.Resource) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	resource.GroupID = server.group.ID
+	server.resources = append(server.resources, resource)
+}
+
+// AddTimeSlot adds the slot, its DayOfWeek is 1 for Sunday.
+func (server *Server) AddTimeSlot(slot lis.TimeSlot) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	slot.GroupID = server.group.ID
+	server.timeSlots = append(server.timeSlots, slot)
+}
+
+// Book makes the booking as if the user booked it, e.g. to take a slot
+// before the test. The date is YYYY-MM-DD.
+func (server *Server) Book(userID int, resourceID int, timeSlotID int, date string, description string) (lis.Boooking, error) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	slot, err := server.bookedTimeSlot(timeSlotID, date)
+	if err != nil {
+		return lis.Boooking{}, err
+	}
+	return server.book(lis.BookingRequest{
+		ResourceID:       resourceID,
+		Description:      description,
+		BookedTimeSlotID: slot.ID,
+		BookedByUserID:   userID,
+		BookedWhen:       date,
+	})
+}
+
+// Bookings returns all the bookings in the order they were made.
+func (server *Server) Bookings() []lis.Boooking {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return append([]lis.Boooking(nil), server.bookings...)
+}
+
+// Requests returns the served requests as "METHOD /path", the injected
+// failures included.
+func (server *Server) Requests() []string {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return append([]string(nil), server.requests...)
+}
+
+// SetLatency delays every response.
+func (server *Server) SetLatency(latency time.Duration) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.latency = latency
+}
+
+func (server *Server) Fail(failure Failure) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.failures = append(server.failures, failure)
+}
+
+// ExpireSessions logs everyone out, the next requests get 403 until the
+// clients authorise again.
+func (server *Server) ExpireSessions() {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.sessions = make(map[string]uint64)
+}
+
+func writeJSON(w http.ResponseWriter, status int, document interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(document)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.lock.Lock()
+	latency := server.latency
+	server.lock.Unlock()
+	time.Sleep(latency)
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.requests = append(server.requests, r.Method+" "+r.URL.Path)
+	if server.injected(w, r) {
+		return
+	}
+	path := strings.Trim(r.URL.Path, "/")
+	if path == "sessions" {
+		server.serveSessions(w, r)
+		return
+	}
+	userID, ok := server.userOf(r)
+	if !ok {
+		writeError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+	segments := strings.Split(path, "/")
+	switch {
+	case r.Method == "GET" && path == "users":
+		writeJSON(w, http.StatusOK, map[string][]lis.User{"users": server.users})
+	case r.Method == "GET" && path == "resources":
+		writeJSON(w, http.StatusOK, map[string][]lis.Resource{"resources": server.resources})
+	case r.Method == "GET" && path == "time_slots":
+		writeJSON(w, http.StatusOK, map[string][]lis.TimeSlot{"time_slots": server.timeSlots})
+	case r.Method == "GET" && len(segments) == 2 && segments[0] == "groups":
+		if segments[1] != strconv.Itoa(server.group.ID) {
+			writeError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		writeJSON(w, http.StatusOK, server.group)
+	case r.Method == "GET" && len(segments) == 5 && segments[1] == "week":
+		server.serveWeek(w, segments[0], segments[2:])
+	case r.Method == "POST" && path == "booked_time_slots":
+		server.postBookedTimeSlot(w, r)
+	case r.Method == "POST" && path == "bookings":
+		server.postBooking(w, r, userID)
+	case r.Method == "DELETE" && len(segments) == 2 && segments[0] == "bookings":
+		server.deleteBooking(w, segments[1], userID)
+	default:
+		writeError(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+func (server *Server) injected(w http.ResponseWriter, r *http.Request) bool {
+	for index, failure := range server.failures {
+		if failure.Method != "" && failure.Method != r.Method || !strings.HasPrefix(r.URL.Path, failure.Path) {
+			continue
+		}
+		if failure.Times > 0 {
+			server.failures[index].Times--
+			if server.failures[index].Times == 0 {
+				server.failures = append(server.failures[:index], server.failures[index+1:]...)
+			}
+		}
+		writeError(w, failure.Status, "injected failure")
+		return true
+	}
+	return false
+}
+
+func (server *Server) userOf(r *http.Request) (uint64, bool) {
+	cookie, err := r.Cookie("session")
+	if err != nil {
+		return 0, false
+	}
+	userID, ok := server.sessions[cookie.Value]
+	return userID, ok
+}
+
+func (server *Server) findUser(userID int) *lis.User {
+	for index := range server.users {
+		if server.users[index].ID == userID {
+			return &server.users[index]
+		}
+	}
+	return nil
+}
+
+func (server *Server) serveSessions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		userID, ok := server.userOf(r)
+		if !ok {
+			writeError(w, http.StatusForbidden, "Forbidden")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string][]lis.Session{"sessions": {server.session(userID)}})
+	case "POST":
+		body, _ := ioutil.ReadAll(r.Body)
+		credentials := lis.SessionRequest{}
+		err := json.Unmarshal(body, &credentials)
+		password, known := server.passwords[credentials.Username]
+		if err != nil || !known || password != credentials.Password || credentials.Groupname != server.group.Groupname {
+			writeError(w, http.StatusForbidden, "Forbidden")
+			return
+		}
+		userID := uint64(0)
+		for _, user := range server.users {
+			if user.Username == credentials.Username {
+				userID = uint64(user.ID)
+			}
+		}
+		token := make([]byte, 16)
+		rand.Read(token)
+		value := hex.EncodeToString(token)
+		server.sessions[value] = userID
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: value, Path: "/", HttpOnly: true})
+		writeJSON(w, http.StatusOK, server.session(userID))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+	}
+}
+
+func (server *Server) session(userID uint64) lis.Session {
+	return lis.Session{
+		GroupId:   uint64(server.group.ID),
+		Id:        fmt.Sprintf("session-%d", userID),
+		LastLogin: time.Now().UTC().Format("2006-01-02 15:04:05"),
+		UserId:    userID,
+	}
+}
+
+func (server *Server) serveWeek(w http.ResponseWriter, kind string, parts []string) {
+	date, err := time.Parse("2006/01/02", strings.Join(parts, "/"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	start := lis.WeekStart(date, server.group.FirstDayOfWeek)
+	inWeek := make(map[int]bool)
+	slots := make([]lis.BookedTimeSlot, 0)
+	for _, slot := range server.bookedTimeSlots {
+		day, _ := time.Parse("2006-01-02", slot.BookingDate)
+		if lis.WeekStart(day, server.group.FirstDayOfWeek).Equal(start) {
+			inWeek[slot.ID] = true
+			slots = append(slots, slot)
+		}
+	}
+	switch kind {
+	case "booked_time_slots":
+		writeJSON(w, http.StatusOK, map[string][]lis.BookedTimeSlot{"booked_time_slots": slots})
+	case "bookings":
+		bookings := make([]lis.Boooking, 0)
+		for _, booking := range server.bookings {
+			if inWeek[booking.BookedTimeSlotID] {
+				bookings = append(bookings, booking)
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string][]lis.Boooking{"bookings": bookings})
+	default:
+		writeError(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+// bookedTimeSlot returns the booked time slot of the slot on the date, a new
+// one if it's the first booking of them.
+func (server *Server) bookedTimeSlot(timeSlotID int, date string) (lis.BookedTimeSlot, error) {
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return lis.BookedTimeSlot{}, fmt.Errorf("bad booking date %s", date)
+	}
+	found := false
+	for _, slot := range server.timeSlots {
+		if slot.ID == timeSlotID {
+			found = true
+			if slot.DayOfWeek != int(day.Weekday())+1 {
+				return lis.BookedTimeSlot{}, fmt.Errorf("time slot %d is not on %s", timeSlotID, day.Weekday())
+			}
+		}
+	}
+	if !found {
+		return lis.BookedTimeSlot{}, fmt.Errorf("unknown time slot %d", timeSlotID)
+	}
+	for _, slot := range server.bookedTimeSlots {
+		if slot.TimeSlotID == timeSlotID && slot.BookingDate == date {
+			return slot, nil
+		}
+	}
+	slot := lis.BookedTimeSlot{ID: server.id(), TimeSlotID: timeSlotID, BookingDate: date, GroupID: server.group.ID}
+	server.bookedTimeSlots = append(server.bookedTimeSlots, slot)
+	return slot, nil
+}
+
+func (server *Server) id() int {
+	server.nextID++
+	return server.nextID
+}
+
+func (server *Server) postBookedTimeSlot(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	request := lis.BookingTimeSlotRequest{}
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad request: %s", err.Error())
+		return
+	}
+	slot, err := server.bookedTimeSlot(request.TimeSlotID, request.BookingDate)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, slot)
+}
+
+// errConflict is the booking of a taken slot.
+type errConflict struct {
+	booking lis.Boooking
+}
+
+func (err errConflict) Error() string {
+	return fmt.Sprintf("slot is already booked by booking %d", err.booking.ID)
+}
+
+func (server *Server) book(request lis.BookingRequest) (lis.Boooking, error) {
+	known := false
+	for _, resource := range server.resources {
+		known = known || resource.ID == request.ResourceID
+	}
+	if !known {
+		return lis.Boooking{}, fmt.Errorf("unknown resource %d", request.ResourceID)
+	}
+	var slot *lis.BookedTimeSlot
+	for index := range server.bookedTimeSlots {
+		if server.bookedTimeSlots[index].ID == request.BookedTimeSlotID {
+			slot = &server.bookedTimeSlots[index]
+		}
+	}
+	if slot == nil {
+		return lis.Boooking{}, fmt.Errorf("unknown booked time slot %d", request.BookedTimeSlotID)
+	}
+	if server.findUser(request.BookedByUserID) == nil {
+		return lis.Boooking{}, fmt.Errorf("unknown user %d", request.BookedByUserID)
+	}
+	for _, booking := range server.bookings {
+		if booking.ResourceID == request.ResourceID && booking.BookedTimeSlotID == request.BookedTimeSlotID {
+			return lis.Boooking{}, errConflict{booking: booking}
+		}
+	}
+	booking := lis.Boooking{
+		ID:                server.id(),
+		BookedByUserID:    request.BookedByUserID,
+		BookedTimeSlotID:  request.BookedTimeSlotID,
+		BookedWhen:        slot.BookingDate + " 00:00:00",
+		Description:       request.Description,
+		ResourceID:        request.ResourceID,
+		SecondaryBookings: make([]interface{}, 0),
+	}
+	server.bookings = append(server.bookings, booking)
+	return booking, nil
+}
+
+// mayAlter tells if the user may book or cancel for the other one.
+func (server *Server) mayAlter(userID uint64, otherID int) bool {
+	if uint64(otherID) == userID {
+		return true
+	}
+	user := server.findUser(int(userID))
+	return user != nil && (user.AllowAlterOthers || user.Administrator)
+}
+
+func (server *Server) postBooking(w http.ResponseWriter, r *http.Request, userID uint64) {
+	body, _ := ioutil.ReadAll(r.Body)
+	request := lis.BookingRequest{}
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad request: %s", err.Error())
+		return
+	}
+	if !server.mayAlter(userID, request.BookedByUserID) {
+		writeError(w, http.StatusForbidden, "not allowed to book for user %d", request.BookedByUserID)
+		return
+	}
+	booking, err := server.book(request)
+	if _, conflict := err.(errConflict); conflict {
+		writeError(w, http.StatusConflict, "%s", err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, booking)
+}
+
+func (server *Server) deleteBooking(w http.ResponseWriter, id string, userID uint64) {
+	for index, booking := range server.bookings {
+		if strconv.Itoa(booking.ID) != id {
+			continue
+		}
+		if !server.mayAlter(userID, booking.BookedByUserID) {
+			writeError(w, http.StatusForbidden, "not allowed to cancel booking %s", id)
+			return
+		}
+		server.bookings = append(server.bookings[:index], server.bookings[index+1:]...)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeError(w, http.StatusNotFound, "Not Found")
+}
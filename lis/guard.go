@@ -0,0 +1,329 @@
+package lis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ledgerKeep is how long the entries of the ledger are kept.
+const ledgerKeep = 30 * 24 * time.Hour
+
+// pendingTimeout is how long a pending entry of another process means it is
+// still booking the slot, an older one is taken as a lost response.
+const pendingTimeout = 2 * time.Minute
+
+// errBusy stops the booking of a slot which another process books.
+var errBusy = errors.New("slot is being booked by another process")
+
+// LedgerEntry is a booking made by this client. It's pending from the POST
+// of the booking until its response, so an entry which stays pending means
+// the response was lost and the slot may be booked or not. PID is the
+// process which wrote it.
+type LedgerEntry struct {
+	Key       string    `json:"key"`
+	Date      string    `json:"date"`
+	Time      string    `json:"time"`
+	Court     string    `json:"court"`
+	BookingID int       `json:"booking_id,omitempty"`
+	Pending   bool      `json:"pending"`
+	PID       int       `json:"pid,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// inProgress tells whether the entry is pending in another process which
+// may still wait for the response.
+func (entry LedgerEntry) inProgress() bool {
+	return entry.Pending && entry.PID != os.Getpid() && time.Since(entry.At) < pendingTimeout
+}
+
+// BookingLedger keeps the entries under their idempotency keys in a JSON
+// file shared by the processes booking for the user, an empty path keeps
+// them in memory only. The processes take turns through the lock file next
+// to it, so a booking is checked, marked pending, posted and stored by one
+// process at a time.
+type BookingLedger struct {
+	path    string
+	lock    sync.Mutex
+	file    *os.File
+	entries map[string]LedgerEntry
+}
+
+func NewBookingLedger(path string) *BookingLedger {
+	return &BookingLedger{path: path, entries: make(map[string]LedgerEntry)}
+}
+
+// idempotencyKey is the same for every attempt to book the slot for the
+// user, whatever the court.
+func idempotencyKey(userID uint64, date string, slot string) string {
+	return fmt.Sprintf("%d/%s/%s", userID, date, slot)
+}
+
+// acquire locks the ledger for this goroutine and, through the lock file,
+// for this process until release.
+func (ledger *BookingLedger) acquire() error {
+	ledger.lock.Lock()
+	if ledger.path == "" {
+		return nil
+	}
+	err := os.MkdirAll(filepath.Dir(ledger.path), 0700)
+	if err != nil {
+		ledger.lock.Unlock()
+		return err
+	}
+	file, err := os.OpenFile(ledger.path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		ledger.lock.Unlock()
+		return err
+	}
+	err = lockFile(file)
+	if err != nil {
+		file.Close()
+		ledger.lock.Unlock()
+		return fmt.Errorf("can not lock the ledger %s: %s", ledger.path, err.Error())
+	}
+	ledger.file = file
+	return nil
+}
+
+func (ledger *BookingLedger) release() {
+	if ledger.file != nil {
+		unlockFile(ledger.file)
+		ledger.file.Close()
+		ledger.file = nil
+	}
+	ledger.lock.Unlock()
+}
+
+// load reads the file again, another process may have changed it.
+func (ledger *BookingLedger) load() error {
+	if ledger.path == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(ledger.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	entries := make(map[string]LedgerEntry)
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		return fmt.Errorf("broken ledger %s: %s", ledger.path, err.Error())
+	}
+	ledger.entries = entries
+	return nil
+}
+
+func (ledger *BookingLedger) save() error {
+	for key, entry := range ledger.entries {
+		if time.Since(entry.At) > ledgerKeep {
+			delete(ledger.entries, key)
+		}
+	}
+	if ledger.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(ledger.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	// a reader never sees a half written file
+	file, err := ioutil.TempFile(filepath.Dir(ledger.path), filepath.Base(ledger.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), ledger.path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+func (ledger *BookingLedger) Get(key string) (LedgerEntry, bool, error) {
+	err := ledger.acquire()
+	if err != nil {
+		return LedgerEntry{}, false, err
+	}
+	defer ledger.release()
+	err = ledger.load()
+	if err != nil {
+		return LedgerEntry{}, false, err
+	}
+	entry, ok := ledger.entries[key]
+	return entry, ok, nil
+}
+
+func (ledger *BookingLedger) Put(entry LedgerEntry) error {
+	err := ledger.acquire()
+	if err != nil {
+		return err
+	}
+	defer ledger.release()
+	err = ledger.load()
+	if err != nil {
+		return err
+	}
+	if entry.At.IsZero() {
+		entry.At = time.Now()
+	}
+	if entry.PID == 0 {
+		entry.PID = os.Getpid()
+	}
+	ledger.entries[entry.Key] = entry
+	return ledger.save()
+}
+
+func (ledger *BookingLedger) Delete(key string) error {
+	err := ledger.acquire()
+	if err != nil {
+		return err
+	}
+	defer ledger.release()
+	err = ledger.load()
+	if err != nil {
+		return err
+	}
+	delete(ledger.entries, key)
+	return ledger.save()
+}
+
+// begin locks the ledger for the booking of the entry and marks it pending,
+// unless another process is booking the slot or has written its entry after
+// since, the time the schedule was read. The ledger stays locked until end.
+func (ledger *BookingLedger) begin(entry LedgerEntry, since time.Time) error {
+	err := ledger.acquire()
+	if err != nil {
+		return err
+	}
+	err = ledger.load()
+	if err == nil {
+		other, ok := ledger.entries[entry.Key]
+		if ok && (other.inProgress() || !since.IsZero() && other.At.After(since)) {
+			err = errBusy
+		}
+	}
+	if err == nil {
+		entry.Pending, entry.PID, entry.At = true, os.Getpid(), time.Now()
+		ledger.entries[entry.Key] = entry
+		err = ledger.save()
+	}
+	if err != nil {
+		ledger.release()
+	}
+	return err
+}
+
+// end stores the entry of the booking begun, or forgets the key if it's nil,
+// and unlocks the ledger.
+func (ledger *BookingLedger) end(key string, entry *LedgerEntry) error {
+	defer ledger.release()
+	if entry == nil {
+		delete(ledger.entries, key)
+	} else {
+		entry.PID, entry.At = os.Getpid(), time.Now()
+		ledger.entries[key] = *entry
+	}
+	return ledger.save()
+}
+
+// SetLedger makes the bookings idempotent: a booking whose response was
+// lost is looked for in the schedule before the slot is booked again.
+func (sched *Schedule) SetLedger(ledger *BookingLedger) {
+	sched.ledger = ledger
+}
+
+// SetDuplicateGuard tells whether a booking of the slot on any court stops
+// the schedule from booking it again, not only on the requested courts.
+func (sched *Schedule) SetDuplicateGuard(anyCourt bool) {
+	sched.anyCourtGuard = anyCourt
+}
+
+// duplicateOf returns the booking the booker already has for the slot on
+// the date on one of the courts, nil if there is none. The schedule is read
+// again if the ledger knows of a booking it may miss: a lost response or the
+// booking of another process. errBusy means another process is booking it.
+func (sched *Schedule) duplicateOf(date string, slot string, courts []string) (*BookingDetails, error) {
+	userID, err := sched.bookerID()
+	if err != nil {
+		// bookCourt reports it
+		return nil, nil
+	}
+	key := idempotencyKey(userID, date, slot)
+	if sched.ledger != nil {
+		entry, ok, err := sched.ledger.Get(key)
+		if err != nil {
+			sched.log(LevelWarn, "can not read the ledger", F("error", err))
+		}
+		if ok && entry.inProgress() {
+			sched.log(LevelInfo, "slot is being booked by another process", F("date", date), F("time", slot), F("pid", entry.PID))
+			return nil, errBusy
+		}
+		if ok && (entry.Pending || entry.At.After(sched.readAt)) {
+			sched.log(LevelInfo, "ledger has a booking the schedule may miss, checking the schedule", F("date", date), F("time", slot), F("pending", entry.Pending))
+			err = sched.Refresh()
+			if err != nil {
+				sched.log(LevelWarn, "can not check the lost booking", F("error", err))
+			}
+		}
+	}
+	if sched.anyCourtGuard {
+		courts = nil
+	}
+	for _, booking := range sched.bookingDetails(userID) {
+		if booking.Date != date || booking.Time != slot || !preferred(courts, booking.Court) {
+			continue
+		}
+		sched.log(LevelInfo, "slot is already booked", F("date", date), F("time", slot), F("court", booking.Court), F("booking_id", booking.ID))
+		sched.remember(LedgerEntry{Key: key, Date: date, Time: slot, Court: booking.Court, BookingID: booking.ID})
+		return &booking, nil
+	}
+	return nil, nil
+}
+
+func (sched *Schedule) remember(entry LedgerEntry) {
+	if sched.ledger == nil || sched.dryRun {
+		return
+	}
+	err := sched.ledger.Put(entry)
+	if err != nil {
+		sched.log(LevelWarn, "can not write the ledger", F("error", err))
+	}
+}
+
+// beginBooking takes the ledger for the booking of the entry, false if it
+// is not used. errBusy means another process books the slot.
+func (sched *Schedule) beginBooking(entry LedgerEntry) (bool, error) {
+	if sched.ledger == nil || sched.dryRun {
+		return false, nil
+	}
+	err := sched.ledger.begin(entry, sched.readAt)
+	if err == errBusy {
+		sched.log(LevelInfo, "slot is being booked by another process", F("date", entry.Date), F("time", entry.Time))
+		return false, err
+	}
+	if err != nil {
+		sched.log(LevelWarn, "can not write the ledger", F("error", err))
+		return false, nil
+	}
+	return true, nil
+}
+
+func (sched *Schedule) endBooking(key string, entry *LedgerEntry) {
+	err := sched.ledger.end(key, entry)
+	if err != nil {
+		sched.log(LevelWarn, "can not write the ledger", F("error", err))
+	}
+}
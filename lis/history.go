@@ -0,0 +1,201 @@
+package lis
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Attempt is a single try to book a slot. Failure is empty for the
+// successful ones.
+type Attempt struct {
+	Source      string             `json:"source" yaml:"source"`
+	Date        string             `json:"date" yaml:"date"`
+	Day         string             `json:"day" yaml:"day"`
+	Time        string             `json:"time" yaml:"time"`
+	Court       string             `json:"court" yaml:"court"`
+	Courts      []string           `json:"courts,omitempty" yaml:"courts,omitempty"`
+	For         string             `json:"for,omitempty" yaml:"for,omitempty"`
+	AttemptedAt time.Time          `json:"attempted_at" yaml:"attempted_at"`
+	LatencyMS   map[string]float64 `json:"latency_ms,omitempty" yaml:"latency_ms,omitempty"`
+	BookingID   int                `json:"booking_id,omitempty" yaml:"booking_id,omitempty"`
+	Booked      bool               `json:"booked" yaml:"booked"`
+	Failure     string             `json:"failure,omitempty" yaml:"failure,omitempty"`
+}
+
+// History keeps the attempts in a JSON-lines file, one attempt per line.
+type History struct {
+	path string
+	lock sync.Mutex
+}
+
+// HistoryPath returns $XDG_DATA_HOME/lis/history.jsonl, falling back to
+// ~/.local/share like the XDG spec says.
+func HistoryPath() string {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "lis", "history.jsonl")
+}
+
+func NewHistory(path string) *History {
+	return &History{path: path}
+}
+
+func (history *History) Record(attempt Attempt) error {
+	history.lock.Lock()
+	defer history.lock.Unlock()
+	err := os.MkdirAll(filepath.Dir(history.path), 0700)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(history.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	line, err := json.Marshal(attempt)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// Load reads all the attempts. Broken lines are skipped, so a crash in the
+// middle of writing doesn't make the whole history unreadable.
+func (history *History) Load() ([]Attempt, error) {
+	history.lock.Lock()
+	defer history.lock.Unlock()
+	attempts := make([]Attempt, 0)
+	file, err := os.Open(history.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return attempts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var attempt Attempt
+		if json.Unmarshal(scanner.Bytes(), &attempt) == nil {
+			attempts = append(attempts, attempt)
+		}
+	}
+	return attempts, scanner.Err()
+}
+
+type HistoryFilter struct {
+	Since  time.Time
+	Source string
+	Court  string
+	Failed bool
+}
+
+func (filter HistoryFilter) Apply(attempts []Attempt) []Attempt {
+	filtered := make([]Attempt, 0)
+	for _, attempt := range attempts {
+		if !filter.Since.IsZero() && attempt.AttemptedAt.Before(filter.Since) {
+			continue
+		}
+		if filter.Source != "" && attempt.Source != filter.Source {
+			continue
+		}
+		if filter.Court != "" && attempt.Court != filter.Court {
+			continue
+		}
+		if filter.Failed && attempt.Booked {
+			continue
+		}
+		filtered = append(filtered, attempt)
+	}
+	return filtered
+}
+
+type SourceStats struct {
+	Source       string  `json:"source" yaml:"source"`
+	Attempts     int     `json:"attempts" yaml:"attempts"`
+	Booked       int     `json:"booked" yaml:"booked"`
+	WinRate      float64 `json:"win_rate" yaml:"win_rate"`
+	AvgLatencyMS float64 `json:"avg_latency_ms" yaml:"avg_latency_ms"`
+}
+
+type CourtStats struct {
+	Court  string `json:"court" yaml:"court"`
+	Booked int    `json:"booked" yaml:"booked"`
+	Lost   int    `json:"lost" yaml:"lost"`
+}
+
+type HistoryStats struct {
+	Sources []SourceStats `json:"sources" yaml:"sources"`
+	Courts  []CourtStats  `json:"courts" yaml:"courts"`
+}
+
+// Stats summarises how often every source wins and which courts are lost.
+// The latency is the sum of all the POSTs of an attempt.
+func Stats(attempts []Attempt) HistoryStats {
+	sources := make(map[string]*SourceStats)
+	courts := make(map[string]*CourtStats)
+	latencies := make(map[string]int)
+	for _, attempt := range attempts {
+		source, ok := sources[attempt.Source]
+		if !ok {
+			source = &SourceStats{Source: attempt.Source}
+			sources[attempt.Source] = source
+		}
+		court, ok := courts[attempt.Court]
+		if !ok {
+			court = &CourtStats{Court: attempt.Court}
+			courts[attempt.Court] = court
+		}
+		source.Attempts++
+		if attempt.Booked {
+			source.Booked++
+			court.Booked++
+		} else {
+			court.Lost++
+		}
+		if len(attempt.LatencyMS) > 0 {
+			total := 0.0
+			for _, latency := range attempt.LatencyMS {
+				total += latency
+			}
+			source.AvgLatencyMS += total
+			latencies[attempt.Source]++
+		}
+	}
+
+	stats := HistoryStats{
+		Sources: make([]SourceStats, 0, len(sources)),
+		Courts:  make([]CourtStats, 0, len(courts)),
+	}
+	for name, source := range sources {
+		source.WinRate = float64(source.Booked) / float64(source.Attempts)
+		if latencies[name] > 0 {
+			source.AvgLatencyMS /= float64(latencies[name])
+		}
+		stats.Sources = append(stats.Sources, *source)
+	}
+	for _, court := range courts {
+		stats.Courts = append(stats.Courts, *court)
+	}
+	sort.Slice(stats.Sources, func(i, j int) bool {
+		return stats.Sources[i].Source < stats.Sources[j].Source
+	})
+	sort.Slice(stats.Courts, func(i, j int) bool {
+		return stats.Courts[i].Lost > stats.Courts[j].Lost ||
+			(stats.Courts[i].Lost == stats.Courts[j].Lost && stats.Courts[i].Court < stats.Courts[j].Court)
+	})
+	return stats
+}
@@ -0,0 +1,413 @@
+package lis
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ParseSlotTime turns the description of a time slot like "9am - 2pm",
+// "11:30am - 2pm" or "18:00 - 19:30" into its start and end within a day.
+func ParseSlotTime(description string) (time.Duration, time.Duration, error) {
+	parts := strings.Split(description, "-")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("time slot %q is not a range", description)
+	}
+	bounds := make([]time.Duration, 2)
+	for index, part := range parts {
+		var err error
+		bounds[index], err = parseClock(part)
+		if err != nil {
+			return 0, 0, fmt.Errorf("time slot %q has unknown time %q", description, strings.TrimSpace(part))
+		}
+	}
+	if bounds[1] <= bounds[0] {
+		return 0, 0, fmt.Errorf("time slot %q ends before it starts", description)
+	}
+	return bounds[0], bounds[1], nil
+}
+
+// parseClock turns "9am", "11:30am", "18:00" or "18" into the time since
+// the start of the day.
+func parseClock(value string) (time.Duration, error) {
+	value = strings.ToLower(strings.ReplaceAll(value, " ", ""))
+	var clock time.Time
+	var err error
+	for _, layout := range []string{"3pm", "3:04pm", "15:04", "15"} {
+		clock, err = time.Parse(layout, value)
+		if err == nil {
+			return time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute, nil
+		}
+	}
+	return 0, err
+}
+
+// clockOn returns the moment the clock shows on the day in the location.
+// Adding the clock to the midnight would be an hour off on the days the
+// clocks change.
+func clockOn(day time.Time, clock time.Duration, location *time.Location) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), int(clock/time.Hour), int(clock%time.Hour/time.Minute), 0, 0, location)
+}
+
+type CalendarEvent struct {
+	UID         string    `json:"uid"`
+	Summary     string    `json:"summary"`
+	Description string    `json:"description"`
+	Location    string    `json:"location"`
+	Date        string    `json:"date"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Cancelled   bool      `json:"cancelled"`
+	Sequence    int       `json:"sequence"`
+}
+
+func slotEvent(uid string, date string, slot string, location *time.Location) (CalendarEvent, error) {
+	day, err := time.ParseInLocation("2006-01-02", date, location)
+	if err != nil {
+		return CalendarEvent{}, fmt.Errorf("wrong date %q", date)
+	}
+	start, end, err := ParseSlotTime(slot)
+	if err != nil {
+		return CalendarEvent{}, err
+	}
+	return CalendarEvent{
+		UID:   uid,
+		Date:  date,
+		Start: clockOn(day, start, location),
+		End:   clockOn(day, end, location),
+	}, nil
+}
+
+// BookingEvents makes an event of every booking. The UIDs are built from the
+// booking IDs, so they stay the same between the exports.
+func BookingEvents(bookings []BookingDetails, location *time.Location) []CalendarEvent {
+	events := make([]CalendarEvent, 0, len(bookings))
+	for _, booking := range bookings {
+		event, err := slotEvent(fmt.Sprintf("booking-%d@lis", booking.ID), booking.Date, booking.Time, location)
+		if err != nil {
+			logAt(LevelWarn, "booking is skipped", F("booking_id", booking.ID), F("error", err))
+			continue
+		}
+		event.Summary = fmt.Sprintf("Squash: %s", booking.Court)
+		event.Description = booking.Description
+		event.Location = booking.Court
+		events = append(events, event)
+	}
+	return events
+}
+
+// ScheduleEvents makes an event of every booked cell of the time tables.
+func ScheduleEvents(tables []TimeTable, location *time.Location) []CalendarEvent {
+	events := make([]CalendarEvent, 0)
+	for _, table := range tables {
+		for _, day := range table.Days {
+			for _, cell := range day.Cells {
+				if !cell.Booked {
+					continue
+				}
+				uid := fmt.Sprintf("slot-%d-%d-%s@lis", table.ID, cell.ID, day.Date)
+				if cell.Mine {
+					uid = fmt.Sprintf("booking-%d@lis", cell.BookingID)
+				}
+				event, err := slotEvent(uid, day.Date, cell.Time, location)
+				if err != nil {
+					logAt(LevelWarn, "cell is skipped", F("date", day.Date), F("time", cell.Time), F("error", err))
+					continue
+				}
+				event.Summary = fmt.Sprintf("Booked: %s", table.Name)
+				if cell.Mine {
+					event.Summary = fmt.Sprintf("Squash: %s", table.Name)
+				}
+				event.Location = table.Name
+				events = append(events, event)
+			}
+		}
+	}
+	return events
+}
+
+// Calendar remembers the exported events, so an event which disappears
+// from the schedule is exported as cancelled instead of vanishing silently.
+type Calendar struct {
+	path   string
+	events map[string]CalendarEvent
+	lock   sync.Mutex
+}
+
+func CalendarPath() string {
+	history := HistoryPath()
+	if history == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(history), "calendar.json")
+}
+
+// NewCalendar loads the state from the path, an empty path keeps the state
+// in memory only.
+func NewCalendar(path string) (*Calendar, error) {
+	calendar := Calendar{
+		path:   path,
+		events: make(map[string]CalendarEvent),
+	}
+	if path == "" {
+		return &calendar, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &calendar, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &calendar.events)
+	if err != nil {
+		return nil, fmt.Errorf("can not parse %s: %s", path, err.Error())
+	}
+	return &calendar, nil
+}
+
+func (calendar *Calendar) save() error {
+	if calendar.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(calendar.events)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(calendar.path), 0700)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(calendar.path, data, 0600)
+}
+
+// ofBooking tells whether the event is of my booking, not a slot of the
+// others which only the exports of all the slots have.
+func (event CalendarEvent) ofBooking() bool {
+	return strings.HasPrefix(event.UID, "booking-")
+}
+
+// Merge stores the current events of the dates from first to last and marks
+// the remembered events of those dates which are gone as cancelled. All
+// tells the export has the slots of the others too, otherwise only the
+// events of my bookings are merged. The remembered events of that kind are
+// returned, the ones before first are forgotten.
+func (calendar *Calendar) Merge(events []CalendarEvent, first string, last string, all bool) ([]CalendarEvent, error) {
+	calendar.lock.Lock()
+	defer calendar.lock.Unlock()
+	current := make(map[string]bool)
+	for _, event := range events {
+		current[event.UID] = true
+		known, ok := calendar.events[event.UID]
+		if ok {
+			event.Sequence = known.Sequence
+			if known.Cancelled || known.Start != event.Start || known.End != event.End || known.Summary != event.Summary {
+				event.Sequence++
+			}
+		}
+		calendar.events[event.UID] = event
+	}
+	merged := make([]CalendarEvent, 0, len(calendar.events))
+	for uid, event := range calendar.events {
+		if event.Date < first {
+			delete(calendar.events, uid)
+			continue
+		}
+		if !all && !event.ofBooking() {
+			continue
+		}
+		if !current[uid] && !event.Cancelled && event.Date <= last {
+			event.Cancelled = true
+			event.Sequence++
+			calendar.events[uid] = event
+		}
+		merged = append(merged, event)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Start.Before(merged[j].Start) || (merged[i].Start.Equal(merged[j].Start) && merged[i].UID < merged[j].UID)
+	})
+	return merged, calendar.save()
+}
+
+// Cancel marks the event of the booking as cancelled right away.
+func (calendar *Calendar) Cancel(bookingID int) error {
+	calendar.lock.Lock()
+	defer calendar.lock.Unlock()
+	uid := fmt.Sprintf("booking-%d@lis", bookingID)
+	event, ok := calendar.events[uid]
+	if !ok || event.Cancelled {
+		return nil
+	}
+	event.Cancelled = true
+	event.Sequence++
+	calendar.events[uid] = event
+	return calendar.save()
+}
+
+func escapeText(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(text)
+}
+
+// foldLine splits the content line into the lines of 75 octets at most as
+// RFC 5545 requires, not breaking UTF-8 sequences.
+func foldLine(line string) string {
+	var folded strings.Builder
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		folded.WriteString(line[:cut])
+		folded.WriteString("\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	folded.WriteString(line)
+	folded.WriteString("\r\n")
+	return folded.String()
+}
+
+func WriteICS(w io.Writer, name string, events []CalendarEvent) error {
+	utc := func(moment time.Time) string {
+		return moment.UTC().Format("20060102T150405Z")
+	}
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//LIS//Let It Sleep//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:" + escapeText(name),
+	}
+	stamp := utc(time.Now())
+	for _, event := range events {
+		status := "CONFIRMED"
+		if event.Cancelled {
+			status = "CANCELLED"
+		}
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			"UID:"+event.UID,
+			"DTSTAMP:"+stamp,
+			"DTSTART:"+utc(event.Start),
+			"DTEND:"+utc(event.End),
+			"SUMMARY:"+escapeText(event.Summary),
+			"LOCATION:"+escapeText(event.Location),
+			"STATUS:"+status,
+			fmt.Sprintf("SEQUENCE:%d", event.Sequence),
+		)
+		if event.Description != "" {
+			lines = append(lines, "DESCRIPTION:"+escapeText(event.Description))
+		}
+		lines = append(lines, "END:VEVENT")
+	}
+	lines = append(lines, "END:VCALENDAR")
+	for _, line := range lines {
+		_, err := io.WriteString(w, foldLine(line))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CalendarExport collects the events of the given number of weeks starting
+// from the week of the session.
+type CalendarExport struct {
+	Weeks    int
+	All      bool
+	Location *time.Location
+	Calendar *Calendar
+}
+
+func (export CalendarExport) Events(sched *Schedule) ([]CalendarEvent, error) {
+	location := export.Location
+	if location == nil {
+		location = time.Local
+	}
+	events := make([]CalendarEvent, 0)
+	first, last := "", ""
+	err := sched.ForWeeks(export.Weeks, func() error {
+		start := sched.weekStart()
+		if first == "" {
+			first = start.Format("2006-01-02")
+		}
+		last = start.AddDate(0, 0, 6).Format("2006-01-02")
+		if export.All {
+			events = append(events, ScheduleEvents(sched.RenderSchedule(), location)...)
+		} else {
+			events = append(events, BookingEvents(sched.MyBookings(), location)...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if export.Calendar == nil {
+		return events, nil
+	}
+	return export.Calendar.Merge(events, first, last, export.All)
+}
+
+// CalendarFeed serves the export as a subscribable .ics feed. Calendar apps
+// can't send headers, so the token is taken from the query.
+type CalendarFeed struct {
+	session *Client
+	token   string
+	export  CalendarExport
+	lock    sync.Mutex
+}
+
+// NewCalendarFeed makes the feed working on its own copy of the session, so
+// it doesn't change the week of the schedules using the original one.
+func NewCalendarFeed(session *Client, token string, export CalendarExport) (*CalendarFeed, error) {
+	if token == "" {
+		return nil, fmt.Errorf("feed token is required")
+	}
+	return &CalendarFeed{
+		session: session.fork(),
+		token:   token,
+		export:  export,
+	}, nil
+}
+
+func (feed *CalendarFeed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/calendar.ics" {
+		http.NotFound(w, r)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(feed.token)) != 1 {
+		http.Error(w, "wrong token", http.StatusUnauthorized)
+		return
+	}
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+	err := feed.session.Authorise()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	sched, err := NewSchedule(feed.session)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	events, err := feed.export.Events(sched)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	WriteICS(w, "Squash", events)
+}
@@ -2,17 +2,26 @@ package lis
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/net/publicsuffix"
 )
 
-type instance struct {
+// Client is a session with the booking API: the credentials, the cookies of
+// the session and the settings of the requests. It's made by NewInstance and
+// shared by the schedules, daemons and servers using it.
+type Client struct {
 	endpoint  string
 	userID    uint64
 	username  string
@@ -22,123 +31,307 @@ type instance struct {
 	cookie    *cookiejar.Jar
 	http_cli  *http.Client
 	faketime  *string
+	notifier  Notifier
+	pending   *sync.WaitGroup
+	auth      *sync.Mutex
+	logger    Logger
+	transport http.RoundTripper
+	client    *http.Client
+	tlsConfig *tls.Config
+	proxy     *url.URL
+	userAgent string
+	headers   http.Header
 }
 
-func NewInstance(endpoint string, username string, password string, groupname string) *instance {
-	inst := instance{
-		endpoint:  endpoint,
+const userAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/58.0.3029.110 Safari/537.36"
+
+var requestCounter uint64
+
+// NewInstance returns a client which is not authorised yet, see Authorise.
+func NewInstance(endpoint string, username string, password string, groupname string, options ...Option) *Client {
+	inst := Client{
+		endpoint:  strings.TrimRight(endpoint, "/"),
 		username:  username,
 		password:  password,
 		groupname: groupname,
 		userID:    0,
+		userAgent: userAgent,
+		headers:   make(http.Header),
+		pending:   &sync.WaitGroup{},
+		auth:      &sync.Mutex{},
 	}
-	cookiejar, err := cookiejar.New(
+	// New never fails, the error is kept for the future options
+	jar, _ := cookiejar.New(
 		&cookiejar.Options{
 			PublicSuffixList: publicsuffix.List,
 		},
 	)
-	if err != nil {
-		log.Fatal(err)
+	inst.cookie = jar
+	for _, option := range options {
+		option(&inst)
 	}
-	inst.cookie = cookiejar
 	return &inst
 }
 
-func (inst *instance) GetEndpoint() string {
+func (inst *Client) GetEndpoint() string {
 	return inst.endpoint
 }
 
-func (inst *instance) SetFaketime(new_time string) {
+func (inst *Client) SetFaketime(new_time string) {
 	faketime := string(new_time)
 	inst.faketime = &faketime
 }
 
-func (inst *instance) GetFakeTime() string {
+func (inst *Client) GetFakeTime() string {
 	if inst.faketime != nil {
 		return *(inst.faketime)
 	}
 	return ""
 }
 
-func (inst *instance) GetGroupId() uint64 {
+func (inst *Client) Username() string {
+	return inst.username
+}
+
+func (inst *Client) Groupname() string {
+	return inst.groupname
+}
+
+func (inst *Client) GetGroupId() uint64 {
 	return inst.groupID
 }
 
-func (inst *instance) GetUserId() uint64 {
+func (inst *Client) GetUserId() uint64 {
 	return inst.userID
 }
 
-func (inst *instance) initClient() error {
+// SetNotifier makes the client and the schedules using it send their
+// events to the notifier.
+func (inst *Client) SetNotifier(notifier Notifier) {
+	inst.notifier = notifier
+}
+
+// notify sends the event in the background, so a slow receiver never
+// delays a booking. Flush waits for the events sent.
+func (inst *Client) notify(event Event) {
+	if inst.notifier == nil {
+		return
+	}
+	event.Username = inst.username
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+	inst.pending.Add(1)
+	go func(notifier Notifier) {
+		defer inst.pending.Done()
+		err := notifier.Notify(event)
+		if err != nil {
+			inst.log(LevelWarn, "notification failed", F("event", event.Type), F("error", err))
+		}
+	}(inst.notifier)
+}
+
+// Flush waits until the events sent in the background are delivered or
+// the timeout is over, so they are not lost when the process exits. False
+// means some of them are still being sent.
+func (inst *Client) Flush(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		inst.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		inst.log(LevelWarn, "notifications are not delivered in time", F("timeout", timeout.String()))
+		return false
+	}
+}
+
+// SetLogger makes the client and the schedules using it log to the
+// logger instead of DefaultLogger.
+func (inst *Client) SetLogger(logger Logger) {
+	inst.logger = logger
+}
+
+// secrets are the values never to be logged: the password and the session
+// cookies.
+func (inst *Client) secrets() []string {
+	secrets := []string{inst.password}
+	endpoint, err := url.Parse(inst.endpoint)
+	if err == nil && inst.cookie != nil {
+		for _, cookie := range inst.cookie.Cookies(endpoint) {
+			secrets = append(secrets, cookie.Value)
+		}
+	}
+	return secrets
+}
+
+func (inst *Client) log(level Level, message string, fields ...Field) {
+	emit(inst.logger, level, message, fields, inst.secrets())
+}
+
+// do sends the request with a request ID, then logs and counts it.
+func (inst *Client) do(req *http.Request, handler string) (*http.Response, error) {
+	requestID := fmt.Sprintf("%08x", atomic.AddUint64(&requestCounter, 1))
+	req.Header.Set("User-Agent", inst.userAgent)
+	for name, values := range inst.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("X-Request-ID", requestID)
+	started := time.Now()
+	resp, err := inst.http_cli.Do(req)
+	countRequest(req.Method, handler, statusOf(resp), err)
+	fields := []Field{
+		F("request_id", requestID),
+		F("method", req.Method),
+		F("endpoint", handler),
+		F("duration_ms", float64(time.Since(started).Microseconds())/1000),
+	}
+	if err != nil {
+		inst.log(LevelWarn, "request failed", append(fields, F("error", err))...)
+		return resp, err
+	}
+	inst.log(LevelDebug, "request", append(fields, F("status", resp.StatusCode))...)
+	return resp, nil
+}
+
+// fork returns a copy of the client sharing the session cookies but
+// having its own faketime.
+func (inst *Client) fork() *Client {
+	copied := *inst
+	copied.faketime = nil
+	if inst.faketime != nil {
+		copied.SetFaketime(*inst.faketime)
+	}
+	return &copied
+}
+
+func (inst *Client) initClient() error {
 	if inst.http_cli != nil {
 		return nil
 	}
-	inst.http_cli = &http.Client{
-		Jar: inst.cookie,
+	client := http.Client{}
+	if inst.client != nil {
+		client = *inst.client
+	}
+	// the session lives in the own jar of the client, so it's shared by
+	// the forks and redacted in the logs
+	client.Jar = inst.cookie
+	transport := inst.roundTripper()
+	if transport != nil {
+		client.Transport = transport
 	}
+	inst.http_cli = &client
 	return nil
 }
 
-func Get(inst *instance, handler string) (int, *http.Response, error) {
-	log.Printf("try to get %s", handler)
-	inst.initClient()
-	req, err := http.NewRequest(
-		"GET",
-		fmt.Sprintf("%s/%s", inst.endpoint, handler),
-		nil,
-	)
-	if err != nil {
-		log.Printf("error with request: %s", err.Error())
-		return 0, nil, err
+// roundTripper applies the TLS config and the proxy to the transport, they
+// need an *http.Transport underneath.
+func (inst *Client) roundTripper() http.RoundTripper {
+	transport := inst.transport
+	if inst.tlsConfig == nil && inst.proxy == nil {
+		return transport
 	}
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/58.0.3029.110 Safari/537.36")
-	resp, err := inst.http_cli.Do(req)
-	if resp != nil {
-		log.Printf("Received response (%d)", resp.StatusCode)
-		return resp.StatusCode, resp, err
+	if transport == nil && inst.client != nil {
+		transport = inst.client.Transport
 	}
-	log.Printf("Data sending failed: %s", err.Error())
-	return 0, nil, err
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	base, ok := transport.(*http.Transport)
+	if !ok {
+		inst.log(LevelWarn, "TLS config and proxy are ignored, the transport is not *http.Transport")
+		return inst.transport
+	}
+	base = base.Clone()
+	if inst.tlsConfig != nil {
+		base.TLSClientConfig = inst.tlsConfig
+	}
+	if inst.proxy != nil {
+		base.Proxy = http.ProxyURL(inst.proxy)
+	}
+	return base
+}
 
+// SetTransport makes the requests go through the transport, e.g. a Recorder
+// or a Replayer. The session cookies are kept.
+func (inst *Client) SetTransport(transport http.RoundTripper) {
+	inst.transport = transport
+	inst.http_cli = nil
 }
 
-func Post(inst *instance, handler string, payload *[]byte) (int, *http.Response, error) {
-	log.Printf("Try to post %s handler", handler)
+// send makes the request to the handler of the API, the payload is sent as
+// JSON if not nil.
+func (inst *Client) send(method string, handler string, payload []byte) (int, *http.Response, error) {
 	inst.initClient()
-	var req *http.Request
-	var err error
-	var reader *bytes.Reader = nil
+	var body io.Reader
 	if payload != nil {
-		reader = bytes.NewReader(*payload)
+		body = bytes.NewReader(payload)
 	}
-	req, err = http.NewRequest(
-		"POST",
-		fmt.Sprintf("%s/%s", inst.endpoint, handler),
-		reader,
-	)
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/%s", inst.endpoint, handler), body)
 	if err != nil {
-		log.Printf("error with request building: %s", err.Error())
+		inst.log(LevelError, "can not build the request", F("method", method), F("endpoint", handler), F("error", err))
 		return 0, nil, err
 	}
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/58.0.3029.110 Safari/537.36")
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := inst.http_cli.Do(req)
+	if method != "GET" {
+		req.Header.Set("Accept", "application/json")
+	}
+	if method == "POST" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := inst.do(req, handler)
 	if err != nil {
-		log.Printf("error with request sending: %s", err.Error())
 		return 0, nil, err
 	}
-	return resp.StatusCode, resp, err
+	return resp.StatusCode, resp, nil
+}
+
+// Request implements API for the schedules using the client.
+func (inst *Client) Request(method string, handler string, payload []byte) (int, []byte, error) {
+	code, resp, err := inst.send(method, handler, payload)
+	if err != nil {
+		return code, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return code, body, err
+}
 
+// Get, Post and Delete make a request to the handler of the API, e.g.
+// "bookings/11764275", with the session of the client.
+func Get(inst *Client, handler string) (int, *http.Response, error) {
+	return inst.send("GET", handler, nil)
 }
 
-func getSessions(inst *instance) (int, error) {
+func Post(inst *Client, handler string, payload *[]byte) (int, *http.Response, error) {
+	var body []byte
+	if payload != nil {
+		body = *payload
+	}
+	return inst.send("POST", handler, body)
+}
+
+func Delete(inst *Client, handler string) (int, *http.Response, error) {
+	return inst.send("DELETE", handler, nil)
+}
+
+func statusOf(resp *http.Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+func getSessions(inst *Client) (int, error) {
 	code, _, err := Get(inst, "sessions")
 	return code, err
 }
 
-func postSessions(inst *instance) (int, *http.Response, error) {
+func postSessions(inst *Client) (int, *http.Response, error) {
 	inst.initClient()
-	log.Println("Post session try")
+	inst.log(LevelDebug, "logging in", F("username", inst.username), F("group", inst.groupname))
 	credentials := SessionRequest{
 		Groupname: inst.groupname,
 		Username:  inst.username,
@@ -154,28 +347,29 @@ func postSessions(inst *instance) (int, *http.Response, error) {
 	if err != nil {
 		return 0, nil, err
 	}
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/58.0.3029.110 Safari/537.36")
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Content-Type", "application/json")
-	resp, err := inst.http_cli.Do(req)
+	resp, err := inst.do(req, "sessions")
 	if resp != nil {
 		return resp.StatusCode, resp, err
 	}
 	return 0, nil, err
 }
 
-func (inst *instance) Authorise() error {
+func (inst *Client) Authorise() error {
+	// the jobs of the daemon sharing a release authorise together
+	inst.auth.Lock()
+	defer inst.auth.Unlock()
 	code, _ := getSessions(inst)
 	if code == 403 {
+		expired := inst.userID != 0
 		code, response, _ := postSessions(inst)
 		if code != 200 {
-			buf := make([]byte, response.ContentLength+1)
-			response.Body.Read(buf)
-			return fmt.Errorf(
-				"wrong credentials for %s: %s",
-				inst.username,
-				string(buf[:]),
-			)
+			if response != nil {
+				buf, _ := ioutil.ReadAll(response.Body)
+				inst.log(LevelWarn, "authorisation rejected", F("status", code), F("response", string(buf)))
+			}
+			return fmt.Errorf("wrong credentials for %s", inst.username)
 		}
 		body, err := ioutil.ReadAll(response.Body)
 		if err != nil {
@@ -185,7 +379,11 @@ func (inst *instance) Authorise() error {
 		json.Unmarshal(body, &session)
 		inst.groupID = session.GroupID
 		inst.userID = session.UserID
-
+		if expired {
+			metrics.reauths.Inc()
+			inst.log(LevelInfo, "session has expired, authorised again", F("username", inst.username))
+			inst.notify(Event{Type: EventSessionReauth})
+		}
 	}
 	code, err := getSessions(inst)
 	if code != 200 {
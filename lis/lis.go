@@ -1,73 +1,886 @@
 package lis
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/akamensky/argparse"
+	"golang.org/x/term"
 )
 
 type LISConfig struct {
+	command   string
 	endpoint  string
 	username  string
-	password  string
+	password  CredentialProvider
 	groupname string
 	day       string
 	time      string
 	details   string
+	court     string
+	courts    []string
+	bookingID int
+	interval  time.Duration
+	timeout   time.Duration
+	at        time.Time
+	attempts  int
+	grid      GridOptions
+	jobs      []Job
+	count     int
+	history   string
+	filter    HistoryFilter
+	stats     bool
+	listen    string
+	token     string
+	calendar  CalendarExport
+	file      string
+	format    string
+	data      string
+	weeks     int
+	feed      string
+	metrics   string
+	from      string
+	poll      time.Duration
+	notifier  Notifier
+	smtp      *SMTPConfig
+	record    string
+	replay    string
+	dryRun    bool
+	anyCourt  bool
+	rules     *Rules
+	forUser   string
+	output    string
+}
+
+func addSlotArgs(cmd *argparse.Command) (*string, *string, *string, *string, *string) {
+	day := cmd.String("d", "day", &argparse.Options{Required: true, Help: "Day to try book the slot"})
+	time := cmd.String("t", "time", &argparse.Options{Required: true, Help: "Time Slot to try book"})
+	details := cmd.String("s", "description", &argparse.Options{Help: "Comment for your booking. Default: profile description or \"To Play\""})
+	court := cmd.String("c", "court", &argparse.Options{Help: "Court to book. Default: profile courts in order"})
+	forUser := cmd.String("", "for", &argparse.Options{Help: "Book for the user with the username or name, it needs the permission to book for others"})
+	return day, time, details, court, forUser
+}
+
+func parseDuration(out printer, name string, value string) time.Duration {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		out.fail(1, "Error on parsing %s: %s", name, err)
+	}
+	return duration
+}
+
+// parseReleaseTime accepts either "YYYY-MM-DD HH:MM" or "HH:MM" for today,
+// both in the local timezone.
+func parseReleaseTime(out printer, value string) time.Time {
+	at, err := time.ParseInLocation("2006-01-02 15:04", value, time.Local)
+	if err == nil {
+		return at
+	}
+	clock, err := time.ParseInLocation("15:04", value, time.Local)
+	if err != nil {
+		out.fail(1, "Error on parsing release time %s: should be \"YYYY-MM-DD HH:MM\" or \"HH:MM\"", value)
+	}
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, time.Local)
 }
 
 func retConfig() *LISConfig {
-	parser := argparse.NewParser("Let It Sleep", "Initial config for the tool")
-	endpoint := parser.String("e", "endpoint", &argparse.Options{Help: "Endpoint of the API", Required: true})
-	username := parser.String("u", "username", &argparse.Options{Required: true})
-	password := parser.String("p", "password", &argparse.Options{Required: true})
-	groupname := parser.String("g", "group", &argparse.Options{Required: true, Help: "Group ID in login form"})
-	day := parser.String("d", "day", &argparse.Options{Required: true, Help: "Day to try book the slot"})
-	time := parser.String("t", "time", &argparse.Options{Required: true, Help: "Time Slot to try book"})
-	details := parser.String("s", "description", &argparse.Options{Help: "Comment for your booking", Default: "To Play"})
+	parser := argparse.NewParser("Let It Sleep", "Automatic booking of squash courts")
+	endpoint := parser.String("e", "endpoint", &argparse.Options{Help: "Endpoint of the API"})
+	username := parser.String("u", "username", &argparse.Options{})
+	password := parser.String("p", "password", &argparse.Options{Help: "Password, prefer the safer sources below"})
+	passwordFile := parser.String("", "password-file", &argparse.Options{Help: "Read the password from the file with 0600 permissions"})
+	passwordCmd := parser.String("", "password-cmd", &argparse.Options{Help: "Take the password from the command output"})
+	passwordPrompt := parser.Flag("", "password-prompt", &argparse.Options{Help: "Ask for the password"})
+	groupname := parser.String("g", "group", &argparse.Options{Help: "Group ID in login form"})
+	configPath := parser.String("", "config", &argparse.Options{Help: "Path to the config file", Default: ConfigPath()})
+	profileName := parser.String("", "profile", &argparse.Options{Help: "Profile of the config file to use"})
+	historyPath := parser.String("", "history", &argparse.Options{Help: "Path to the history of attempts, empty to disable", Default: HistoryPath()})
+	output := parser.Selector("o", "output", outputFormats, &argparse.Options{Help: "Output format", Default: "text"})
+	logLevel := parser.Selector("", "log-level", levelNames, &argparse.Options{Help: "Least level of the logged messages", Default: "info"})
+	logJSON := parser.Flag("", "log-json", &argparse.Options{Help: "Log JSON objects instead of text lines"})
+	record := parser.String("", "record", &argparse.Options{Help: "Record the traffic with the API into the cassette file"})
+	replay := parser.String("", "replay", &argparse.Options{Help: "Serve the responses from the cassette file instead of the API"})
+	anyCourt := parser.Flag("", "guard-any-court", &argparse.Options{Help: "Don't book the slot if I have it on any court, not only on the requested ones"})
+	fallback := parser.Flag("", "fallback-any-court", &argparse.Options{Help: "Book any court when none of the profile courts is free"})
+	dryRun := parser.Flag("", "dry-run", &argparse.Options{Help: "Log the booking requests of book, cancel, watch, snipe and daemon instead of sending them"})
+
+	loginCmd := parser.NewCommand("login", "Check the credentials")
+
+	showCmd := parser.NewCommand("show", "Show the schedule of the current week")
+	showCourt := showCmd.String("c", "court", &argparse.Options{Help: "Show only this court"})
+	showDay := showCmd.String("d", "day", &argparse.Options{Help: "Show only this day"})
+	showFree := showCmd.Flag("f", "free-only", &argparse.Options{Help: "Show only free slots"})
+	showColour := showCmd.Flag("", "colour", &argparse.Options{Help: "Colour the markers"})
+
+	bookCmd := parser.NewCommand("book", "Book the slot if it is free")
+	bookDay, bookTime, bookDetails, bookCourt, bookFor := addSlotArgs(bookCmd)
+
+	cancelCmd := parser.NewCommand("cancel", "Cancel the booking")
+	bookingID := cancelCmd.Int("i", "id", &argparse.Options{Required: true, Help: "ID of the booking to cancel"})
+
+	mineCmd := parser.NewCommand("mine", "Show my bookings of the current week")
+
+	watchCmd := parser.NewCommand("watch", "Wait for the slot to become free and book it")
+	watchDay, watchTime, watchDetails, watchCourt, watchFor := addSlotArgs(watchCmd)
+	watchInterval := watchCmd.String("", "interval", &argparse.Options{Help: "Pause between the checks", Default: "1m"})
+	watchTimeout := watchCmd.String("", "timeout", &argparse.Options{Help: "Stop watching after this duration, 0 is forever", Default: "0"})
+
+	snipeCmd := parser.NewCommand("snipe", "Book the slot right at the moment of its release")
+	snipeDay, snipeTime, snipeDetails, snipeCourt, snipeFor := addSlotArgs(snipeCmd)
+	snipeAt := snipeCmd.String("a", "at", &argparse.Options{Required: true, Help: "Release time: \"YYYY-MM-DD HH:MM\" or \"HH:MM\""})
+	snipeRetry := snipeCmd.String("", "retry", &argparse.Options{Help: "Pause between the attempts", Default: "500ms"})
+	snipeAttempts := snipeCmd.Int("", "attempts", &argparse.Options{Help: "Number of booking attempts", Default: 10})
+
+	daemonCmd := parser.NewCommand("daemon", "Run the booking jobs at the release time of their slots")
+	daemonJobs := daemonCmd.String("j", "jobs", &argparse.Options{Help: "Path to the jobs file", Default: JobsPath()})
+	daemonWake := daemonCmd.String("", "wake", &argparse.Options{Help: "Authorise this long before the release", Default: "1m"})
+	daemonPoll := daemonCmd.String("", "poll", &argparse.Options{Help: "Check the slots of the next games for cancellations this often, 0 disables", Default: "0"})
+	daemonMetrics := daemonCmd.String("", "metrics", &argparse.Options{Help: "Serve the Prometheus metrics on the address"})
+	daemonFeed := daemonCmd.String("", "feed", &argparse.Options{Help: "Serve the iCalendar feed of my bookings on the address"})
+	daemonFeedToken := daemonCmd.String("", "feed-token", &argparse.Options{Help: "Token of the feed, LIS_API_TOKEN by default"})
+
+	planCmd := parser.NewCommand("plan", "Show the next games of the jobs and the status of their slots")
+	planJobs := planCmd.String("j", "jobs", &argparse.Options{Help: "Path to the jobs file", Default: JobsPath()})
+	planCount := planCmd.Int("n", "count", &argparse.Options{Help: "Number of games per job", Default: 5})
+
+	historyCmd := parser.NewCommand("history", "Show the recorded booking attempts")
+	historySince := historyCmd.String("", "since", &argparse.Options{Help: "Show attempts since the date YYYY-MM-DD"})
+	historySource := historyCmd.String("", "source", &argparse.Options{Help: "Show attempts of the source: book, watch, snipe, daemon:<job>"})
+	historyCourt := historyCmd.String("c", "court", &argparse.Options{Help: "Show attempts for the court"})
+	historyFailed := historyCmd.Flag("f", "failed", &argparse.Options{Help: "Show failed attempts only"})
+	historyStats := historyCmd.Flag("", "stats", &argparse.Options{Help: "Show win rate per source and lost courts"})
+
+	icalCmd := parser.NewCommand("ical", "Export my bookings as an iCalendar file")
+	icalAll := icalCmd.Flag("", "all", &argparse.Options{Help: "Export all the booked slots, not only mine"})
+	icalWeeks := icalCmd.Int("w", "weeks", &argparse.Options{Help: "Number of weeks to export from the current one", Default: 2})
+	icalFile := icalCmd.String("f", "file", &argparse.Options{Help: "Write to the file instead of stdout"})
+
+	exportCmd := parser.NewCommand("export", "Export the schedule, bookings or history as a table")
+	exportFormat := exportCmd.Selector("", "format", exportFormats, &argparse.Options{Help: "Table format", Default: "csv"})
+	exportWhat := exportCmd.Selector("", "data", exportData, &argparse.Options{Help: "Data to export: all slots, bookings of all users, my bookings or booking attempts", Default: "schedule"})
+	exportWeeks := exportCmd.Int("w", "weeks", &argparse.Options{Help: "Number of weeks to export from the current one", Default: 1})
+	exportFile := exportCmd.String("f", "file", &argparse.Options{Help: "Write to the file instead of stdout"})
+
+	botCmd := parser.NewCommand("bot", "Answer the chat commands like \"book tue 18:00\" typed in the terminal")
+	botFrom := botCmd.String("", "from", &argparse.Options{Help: "Name of the sender added to the description of the bookings"})
+
+	serveCmd := parser.NewCommand("serve", "Serve the REST API for the schedule and bookings")
+	serveListen := serveCmd.String("l", "listen", &argparse.Options{Help: "Address to listen on", Default: "127.0.0.1:8080"})
+	serveToken := serveCmd.String("", "token", &argparse.Options{Help: "API token, LIS_API_TOKEN by default; generated if not set"})
+
+	out := printer{format: outputOf(os.Args[1:])}
 	err := parser.Parse(os.Args)
-	if err != nil {
-		fmt.Printf("Error on parsing: %s", err)
+	if err != nil && out.format == "text" {
+		fmt.Print(parser.Usage(err))
 		os.Exit(1)
 	}
-	return &LISConfig{
-		endpoint:  *endpoint,
-		username:  *username,
-		password:  *password,
-		groupname: *groupname,
-		day:       *day,
-		time:      *time,
-		details:   *details,
+	if err != nil {
+		out.fail(1, "Error on parsing: %s", err)
+	}
+	level, _ := ParseLevel(*logLevel)
+	DefaultLogger = NewLogger(os.Stderr, level, *logJSON)
+	profile, err := LoadProfile(*configPath, *profileName)
+	if err != nil {
+		out.fail(1, "Error on loading config: %s", err)
+	}
+	config := &LISConfig{
+		notifier:  profile.Notifier(),
+		smtp:      profile.SMTP,
+		endpoint:  firstOf(*endpoint, profile.Endpoint),
+		username:  firstOf(*username, profile.Username),
+		groupname: firstOf(*groupname, profile.Group),
+		output:    *output,
+		history:   *historyPath,
+		record:    *record,
+		replay:    *replay,
+		dryRun:    *dryRun,
+		anyCourt:  *anyCourt,
+		rules:     profile.Rules,
+	}
+	if *dryRun && !(bookCmd.Happened() || cancelCmd.Happened() || watchCmd.Happened() || snipeCmd.Happened() || daemonCmd.Happened()) {
+		out.fail(1, "Error on parsing: --dry-run is supported by book, cancel, watch, snipe and daemon only")
+	}
+	if historyCmd.Happened() {
+		config.command = "history"
+		config.stats = *historyStats
+		config.filter = HistoryFilter{Source: *historySource, Court: *historyCourt, Failed: *historyFailed}
+		if *historySince != "" {
+			config.filter.Since, err = parseDate("since", *historySince)
+			if err != nil {
+				out.fail(1, "Error on parsing: %s", err)
+			}
+		}
+		return config
+	}
+	if exportCmd.Happened() {
+		config.command = "export"
+		config.format, config.data, config.weeks, config.file = *exportFormat, *exportWhat, *exportWeeks, *exportFile
+		if config.data == "history" {
+			return config
+		}
+	}
+	switch {
+	case *password != "":
+		config.password = StaticPassword(*password)
+	case *passwordFile != "":
+		config.password = FilePassword{Path: *passwordFile}
+	case *passwordCmd != "":
+		config.password = CommandPassword{Command: *passwordCmd}
+	case *passwordPrompt:
+		config.password = PromptPassword{Prompt: "Password: "}
+	default:
+		config.password = profile.Credentials()
+	}
+	if config.replay != "" {
+		// the cassette answers anyway
+		config.endpoint = firstOf(config.endpoint, "http://replay.invalid")
+		if config.password == nil {
+			config.password = StaticPassword("")
+		}
+	}
+	if config.password == nil {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			out.fail(1, "Error on parsing: password is not set by flags, environment or profile")
+		}
+		config.password = PromptPassword{Prompt: "Password: "}
+	}
+	required := []struct{ name, value string }{
+		{"endpoint", config.endpoint},
+		{"username", config.username},
+		{"group", config.groupname},
+	}
+	for _, option := range required {
+		if option.value == "" {
+			out.fail(1, "Error on parsing: %s is not set by flags, environment or profile", option.name)
+		}
+	}
+	switch {
+	case loginCmd.Happened():
+		config.command = "login"
+	case showCmd.Happened():
+		config.command = "show"
+		config.grid = GridOptions{
+			Court:    *showCourt,
+			Day:      *showDay,
+			FreeOnly: *showFree,
+			Colour:   *showColour,
+		}
+	case bookCmd.Happened():
+		config.command = "book"
+		config.day, config.time, config.details, config.court = *bookDay, *bookTime, *bookDetails, *bookCourt
+		config.forUser = *bookFor
+	case cancelCmd.Happened():
+		config.command = "cancel"
+		config.bookingID = *bookingID
+	case mineCmd.Happened():
+		config.command = "mine"
+	case watchCmd.Happened():
+		config.command = "watch"
+		config.day, config.time, config.details, config.court = *watchDay, *watchTime, *watchDetails, *watchCourt
+		config.forUser = *watchFor
+		config.interval = parseDuration(out, "interval", *watchInterval)
+		config.timeout = parseDuration(out, "timeout", *watchTimeout)
+	case snipeCmd.Happened():
+		config.command = "snipe"
+		config.day, config.time, config.details, config.court = *snipeDay, *snipeTime, *snipeDetails, *snipeCourt
+		config.forUser = *snipeFor
+		config.at = parseReleaseTime(out, *snipeAt)
+		config.interval = parseDuration(out, "retry", *snipeRetry)
+		config.attempts = *snipeAttempts
+	case daemonCmd.Happened():
+		config.command = "daemon"
+		config.interval = parseDuration(out, "wake", *daemonWake)
+		config.jobs = loadJobs(out, *daemonJobs, profile)
+		config.poll = parseDuration(out, "poll", *daemonPoll)
+		config.feed = *daemonFeed
+		config.metrics = *daemonMetrics
+		config.token = firstOf(*daemonFeedToken, os.Getenv("LIS_API_TOKEN"))
+		config.calendar = CalendarExport{Weeks: 2}
+	case icalCmd.Happened():
+		config.command = "ical"
+		config.calendar = CalendarExport{Weeks: *icalWeeks, All: *icalAll}
+		config.file = *icalFile
+	case botCmd.Happened():
+		config.command = "bot"
+		config.from = *botFrom
+	case serveCmd.Happened():
+		config.command = "serve"
+		config.listen = *serveListen
+		config.token = firstOf(*serveToken, os.Getenv("LIS_API_TOKEN"))
+	case planCmd.Happened():
+		config.command = "plan"
+		config.count = *planCount
+		config.jobs = loadJobs(out, *planJobs, profile)
+	}
+	if config.details == "" {
+		config.details = firstOf(profile.Description, "To Play")
+	}
+	if config.court != "" {
+		config.courts = []string{config.court}
+	} else if len(profile.Courts) > 0 {
+		config.courts = append(config.courts, profile.Courts...)
+		if *fallback || profile.FallbackAnyCourt {
+			// an empty court at the end lets any court be booked as a last resort
+			config.courts = append(config.courts, "")
+		}
 	}
+	return config
 }
 
-func Book() {
+func loadJobs(out printer, path string, profile *Profile) []Job {
+	jobs, err := LoadJobs(path)
+	if err != nil {
+		out.fail(1, "Error on loading jobs: %s", err)
+	}
+	for index := range jobs {
+		if len(jobs[index].Courts) == 0 {
+			jobs[index].Courts = profile.Courts
+		}
+		if jobs[index].Description == "" {
+			jobs[index].Description = firstOf(profile.Description, "To Play")
+		}
+	}
+	return jobs
+}
+
+// outputOf finds the output format among the arguments before they are
+// parsed, so even the errors of parsing them are printed in that format.
+func outputOf(args []string) string {
+	format := "text"
+	for index, arg := range args {
+		for _, name := range []string{"-o", "--output"} {
+			if arg == name && index+1 < len(args) {
+				format = args[index+1]
+			} else if strings.HasPrefix(arg, name+"=") {
+				format = strings.TrimPrefix(arg, name+"=")
+			}
+		}
+	}
+	for _, known := range outputFormats {
+		if format == known {
+			return format
+		}
+	}
+	return "text"
+}
+
+func firstOf(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func Run() {
 	config := retConfig()
-	instance := NewInstance(
+	out := printer{format: config.output}
+	var history *History
+	if config.history != "" {
+		history = NewHistory(config.history)
+	}
+	if config.command == "history" {
+		showHistory(out, config, history)
+		return
+	}
+	if config.command == "export" && config.data == "history" {
+		exportTable(out, config, nil, history)
+		return
+	}
+	instance, err := NewInstanceWithCredentials(
 		config.endpoint,
 		config.username,
 		config.password,
 		config.groupname,
 	)
-	err := instance.Authorise()
 	if err != nil {
-		fmt.Printf("Failed to authorise user: %s\n", err)
-		os.Exit(1)
+		out.fail(1, "Failed to get the password: %s", err)
+	}
+	instance.SetNotifier(config.notifier)
+	setTransport(out, config, instance)
+	err = instance.Authorise()
+	if err != nil {
+		out.fail(1, "Failed to authorise user: %s", err)
+	}
+	if config.smtp != nil && sendsEvents(config.command) {
+		addEmails(config, instance)
+	}
+	if config.command == "login" {
+		result := LoginResult{
+			Username: config.username,
+			UserID:   instance.GetUserId(),
+			GroupID:  instance.GetGroupId(),
+		}
+		out.print(result, func() {
+			fmt.Printf("Authorised as %s (user %d, group %d)\n", result.Username, result.UserID, result.GroupID)
+		})
+		os.Exit(0)
+	}
+	if config.command == "daemon" {
+		runDaemon(out, config, instance)
+		return
+	}
+	if config.command == "serve" {
+		serve(out, config, instance, history)
+		return
+	}
+	if config.command == "export" {
+		exportTable(out, config, instance, history)
+		return
+	}
+	if config.command == "bot" {
+		runBot(out, config, instance, history)
+		return
+	}
+	if config.command == "ical" {
+		exportCalendar(out, config, instance)
+		return
+	}
+	if config.command == "plan" {
+		plan(out, config, instance)
+		return
 	}
 	session, err := NewSchedule(instance)
 	if err != nil {
-		fmt.Printf("Failed on making new session: %s", err.Error())
-		os.Exit(1)
+		out.fail(1, "Failed on making new session: %s", err.Error())
+	}
+	session.SetHistory(history, config.command)
+	session.SetDryRun(config.dryRun)
+	session.SetDuplicateGuard(config.anyCourt)
+	session.SetLedger(newLedger(config))
+	session.SetRules(config.rules)
+	session.SetBookFor(config.forUser)
+	err = session.Refresh()
+	if err != nil {
+		out.fail(1, "Failed to get the schedule: %s", err)
+	}
+	if config.forUser != "" {
+		_, err = session.Booker()
+		if err != nil {
+			out.fail(1, "Failed to book for %s: %s", config.forUser, err)
+		}
 	}
-	session.Refresh()
 
-	booked := session.BookIfPossible(config.day, config.time, config.details)
-	if booked != nil {
-		fmt.Printf("Booked: %s", *booked)
+	switch config.command {
+	case "show":
+		timeTables := session.RenderSchedule()
+		out.print(ScheduleResult{TimeTables: timeTables}, func() {
+			WriteGrid(os.Stdout, timeTables, config.grid)
+		})
+	case "book":
+		booked(out, config, session, session.BookPreferredIfPossible(config.courts, config.day, config.time, config.details))
+	case "cancel":
+		err = session.CancelBooking(config.bookingID)
+		if err != nil {
+			out.fail(2, "Failed with cancelling: %s", err)
+		}
+		if config.dryRun {
+			result := CancelResult{ID: config.bookingID, Cancelled: true, DryRun: true, Requests: session.PlannedRequests()}
+			out.print(result, func() {
+				fmt.Printf("Would cancel: %d\n", config.bookingID)
+				printPlanned(result.Requests)
+			})
+			return
+		}
+		calendar, err := NewCalendar(CalendarPath())
+		if err == nil {
+			err = calendar.Cancel(config.bookingID)
+		}
+		if err != nil {
+			logAt(LevelWarn, "failed to mark the booking cancelled in the calendar", F("error", err))
+		}
+		out.print(CancelResult{ID: config.bookingID, Cancelled: true}, func() {
+			fmt.Printf("Cancelled: %d\n", config.bookingID)
+		})
+	case "mine":
+		bookings := session.MyBookings()
+		out.print(BookingsResult{Bookings: bookings}, func() {
+			for _, booking := range bookings {
+				fmt.Printf("%d\t%s\t%s\t%s\t%s\t%s\n", booking.ID, booking.Date, booking.Day, booking.Time, booking.Court, booking.Description)
+			}
+		})
+	case "watch":
+		booked(out, config, session, session.Watch(config.courts, config.day, config.time, config.details, config.interval, config.timeout))
+	case "snipe":
+		booked(out, config, session, session.Snipe(config.at, config.courts, config.day, config.time, config.details, config.interval, config.attempts))
+	}
+}
+
+// printPlanned prints the requests of the dry run with their notes.
+func printPlanned(requests []PlannedRequest) {
+	for _, request := range requests {
+		fmt.Printf("%s %s %s\n", request.Method, request.Endpoint, request.Payload)
+		if request.Note != "" {
+			fmt.Printf("  (%s)\n", request.Note)
+		}
+	}
+}
+
+// flushTimeout is how long the commands wait for the notifications before
+// exiting, enough for a webhook to retry.
+const flushTimeout = 30 * time.Second
+
+func booked(out printer, config *LISConfig, sched *Schedule, court *string) {
+	result := BookingResult{
+		Booked:      court != nil && !sched.AlreadyHeld(),
+		AlreadyHeld: court != nil && sched.AlreadyHeld(),
+		Court:       config.court,
+		Day:         config.day,
+		Time:        config.time,
+		Description: config.details,
+		For:         config.forUser,
+		DryRun:      sched.DryRun(),
+		Requests:    sched.PlannedRequests(),
+	}
+	if court != nil {
+		result.Court = *court
+	}
+	out.print(result, func() {
+		if result.Booked && result.DryRun {
+			fmt.Printf("Would book: %s\n", result.Court)
+			printPlanned(result.Requests)
+		} else if result.Booked && result.For != "" {
+			fmt.Printf("Booked: %s for %s\n", result.Court, result.For)
+		} else if result.Booked {
+			fmt.Printf("Booked: %s\n", result.Court)
+		} else if result.AlreadyHeld {
+			fmt.Printf("Already booked: %s\n", result.Court)
+		} else {
+			fmt.Println("Failed with booking")
+		}
+	})
+	sched.session.Flush(flushTimeout)
+	if result.Booked || result.AlreadyHeld {
 		os.Exit(0)
+	}
+	os.Exit(2)
+}
+
+func runDaemon(out printer, config *LISConfig, instance *Client) {
+	daemon, err := NewDaemon(instance, config.jobs, config.interval)
+	if err != nil {
+		out.fail(1, "Failed to start the daemon: %s", err)
+	}
+	if config.history != "" {
+		daemon.History = NewHistory(config.history)
+	}
+	daemon.Poll = config.poll
+	daemon.AnyCourtGuard = config.anyCourt
+	daemon.Ledger = newLedger(config)
+	daemon.Rules = config.rules
+	daemon.Report = func(outcome JobOutcome) {
+		out.print(outcome, func() {
+			if outcome.Booked && outcome.DryRun {
+				fmt.Printf("%s: would book %s %s %s\n", outcome.Job, outcome.Date, outcome.Time, outcome.Court)
+			} else if outcome.Booked && outcome.For != "" {
+				fmt.Printf("%s: booked %s %s %s for %s at %s\n", outcome.Job, outcome.Date, outcome.Time, outcome.Court, outcome.For, outcome.Started.Format(time.RFC3339))
+			} else if outcome.Booked {
+				fmt.Printf("%s: booked %s %s %s at %s\n", outcome.Job, outcome.Date, outcome.Time, outcome.Court, outcome.Started.Format(time.RFC3339))
+			} else if outcome.AlreadyHeld {
+				fmt.Printf("%s: already booked %s %s %s\n", outcome.Job, outcome.Date, outcome.Time, outcome.Court)
+			} else {
+				fmt.Printf("%s: failed %s %s: %s\n", outcome.Job, outcome.Date, outcome.Time, outcome.Error)
+			}
+		})
+	}
+	if config.dryRun {
+		for _, outcome := range daemon.DryRunJobs() {
+			daemon.Report(outcome)
+		}
+		return
+	}
+	if config.feed != "" {
+		serveFeed(out, config, instance, daemon.Location())
+	}
+	if config.metrics != "" {
+		logAt(LevelInfo, "serving the metrics", F("url", fmt.Sprintf("http://%s/metrics", config.metrics)))
+		serveInBackground("Metrics", config.metrics, MetricsHandler())
+	}
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+	daemon.Run(stop)
+	instance.Flush(flushTimeout)
+}
+
+// serveFeed starts serving the calendar feed in the background while the
+// daemon runs the jobs.
+func serveFeed(out printer, config *LISConfig, instance *Client, location *time.Location) {
+	calendar, err := NewCalendar(CalendarPath())
+	if err != nil {
+		out.fail(1, "Failed to load the calendar: %s", err)
+	}
+	config.calendar.Location = location
+	config.calendar.Calendar = calendar
+	feed, err := NewCalendarFeed(instance, config.token, config.calendar)
+	if err != nil {
+		out.fail(1, "Failed to start the feed: %s", err)
+	}
+	logAt(LevelInfo, "serving the calendar feed", F("url", fmt.Sprintf("http://%s/calendar.ics?token=...", config.feed)))
+	serveInBackground("Calendar feed", config.feed, feed)
+}
+
+func serveInBackground(name string, address string, handler http.Handler) {
+	httpServer := &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		err := httpServer.ListenAndServe()
+		if err != nil {
+			logAt(LevelError, "server failed", F("server", name), F("error", err))
+		}
+	}()
+}
+
+func exportCalendar(out printer, config *LISConfig, instance *Client) {
+	session, err := NewSchedule(instance)
+	if err != nil {
+		out.fail(1, "Failed on making new session: %s", err.Error())
+	}
+	config.calendar.Location, err = session.Location()
+	if err != nil {
+		out.fail(1, "Failed to get the timezone: %s", err)
+	}
+	config.calendar.Calendar, err = NewCalendar(CalendarPath())
+	if err != nil {
+		out.fail(1, "Failed to load the calendar: %s", err)
+	}
+	events, err := config.calendar.Events(session)
+	if err != nil {
+		out.fail(1, "Failed to get the schedule: %s", err)
+	}
+	writer := os.Stdout
+	if config.file != "" {
+		writer, err = os.Create(config.file)
+		if err != nil {
+			out.fail(1, "Failed to create %s: %s", config.file, err)
+		}
+		defer writer.Close()
+	}
+	err = WriteICS(writer, "Squash", events)
+	if err != nil {
+		out.fail(1, "Failed to write the calendar: %s", err)
+	}
+}
+
+// exportTable writes the selected data as csv or tsv. The history is read
+// from the file, so the instance is not needed for it.
+func exportTable(out printer, config *LISConfig, instance *Client, history *History) {
+	writer := os.Stdout
+	if config.file != "" {
+		var err error
+		writer, err = os.Create(config.file)
+		if err != nil {
+			out.fail(1, "Failed to create %s: %s", config.file, err)
+		}
+		defer writer.Close()
+	}
+	if config.data == "history" {
+		if history == nil {
+			out.fail(1, "History is disabled")
+		}
+		attempts, err := history.Load()
+		if err != nil {
+			out.fail(1, "Failed to read the history: %s", err)
+		}
+		err = WriteHistoryTable(writer, config.format, attempts)
+		if err != nil {
+			out.fail(1, "Failed to export the history: %s", err)
+		}
+		return
+	}
+
+	session, err := NewSchedule(instance)
+	if err != nil {
+		out.fail(1, "Failed on making new session: %s", err.Error())
+	}
+	tables := make([]TimeTable, 0)
+	bookings := make([]BookingDetails, 0)
+	err = session.ForWeeks(config.weeks, func() error {
+		switch config.data {
+		case "schedule":
+			tables = append(tables, session.RenderSchedule()...)
+		case "bookings":
+			bookings = append(bookings, session.Bookings()...)
+		case "mine":
+			bookings = append(bookings, session.MyBookings()...)
+		}
+		return nil
+	})
+	if err != nil {
+		out.fail(1, "Failed to get the schedule: %s", err)
+	}
+	if config.data == "schedule" {
+		err = WriteScheduleTable(writer, config.format, tables)
 	} else {
-		fmt.Print("Failed with booking")
-		os.Exit(2)
+		err = WriteBookingsTable(writer, config.format, bookings)
+	}
+	if err != nil {
+		out.fail(1, "Failed to export the %s: %s", config.data, err)
+	}
+}
+
+// sendsEvents tells whether the command may send events, the others don't
+// need the emails set up.
+func sendsEvents(command string) bool {
+	switch command {
+	case "login", "show", "mine", "cancel", "ical", "export", "plan", "history":
+		return false
+	}
+	return true
+}
+
+// addEmails adds the email notifier for the user of the session, and the
+// teammates booked for, to the notifiers of the instance.
+func addEmails(config *LISConfig, instance *Client) {
+	session, err := NewSchedule(instance)
+	if err == nil {
+		// the users are enough to find me and the teammates
+		session.users, err = session.getUsers()
+	}
+	if err != nil {
+		instance.log(LevelWarn, "emails are disabled, can not read the users", F("error", err))
+		return
+	}
+	user := session.CurrentUser()
+	if user == nil {
+		instance.log(LevelWarn, "emails are disabled, user is not found", F("user_id", instance.GetUserId()))
+		return
+	}
+	emails := NewEmailNotifier(*config.smtp, *user)
+	emails.SetUsers(session.users)
+	notifiers := Notifiers{emails}
+	if config.notifier != nil {
+		notifiers = append(notifiers, config.notifier)
+	}
+	instance.SetNotifier(notifiers)
+}
+
+func runBot(out printer, config *LISConfig, instance *Client, history *History) {
+	bot, err := NewBot(instance)
+	if err != nil {
+		out.fail(1, "Failed to start the bot: %s", err)
+	}
+	bot.Courts = config.courts
+	bot.Description = config.details
+	bot.Schedule().SetHistory(history, "bot")
+	bot.Schedule().SetRules(config.rules)
+	var adapter BotAdapter = StdinAdapter{In: os.Stdin, Out: os.Stdout, From: config.from, Prompt: "> "}
+	err = adapter.Serve(bot.Handle)
+	if err != nil {
+		out.fail(1, "Bot failed: %s", err)
+	}
+}
+
+func plan(out printer, config *LISConfig, instance *Client) {
+	daemon, err := NewDaemon(instance, config.jobs, 0)
+	if err != nil {
+		out.fail(1, "Failed to plan the jobs: %s", err)
+	}
+	games := daemon.Plan(config.count)
+	out.print(PlanResult{Games: games}, func() {
+		for _, game := range games {
+			fmt.Printf("%s\t%s\t%s\t%s\topens %s\t%s\n", game.Date, game.Day, game.Time, game.Job, game.Release.Format("2006-01-02 15:04"), game.Status)
+		}
+	})
+}
+
+func showHistory(out printer, config *LISConfig, history *History) {
+	if history == nil {
+		out.fail(1, "History is disabled")
+	}
+	attempts, err := history.Load()
+	if err != nil {
+		out.fail(1, "Failed to read the history: %s", err)
+	}
+	attempts = config.filter.Apply(attempts)
+	if config.stats {
+		stats := Stats(attempts)
+		out.print(stats, func() {
+			fmt.Println("Source\tAttempts\tBooked\tWin rate\tAvg latency")
+			for _, source := range stats.Sources {
+				fmt.Printf("%s\t%d\t%d\t%.0f%%\t%.1fms\n", source.Source, source.Attempts, source.Booked, source.WinRate*100, source.AvgLatencyMS)
+			}
+			fmt.Println("\nCourt\tBooked\tLost")
+			for _, court := range stats.Courts {
+				fmt.Printf("%s\t%d\t%d\n", firstOf(court.Court, "any"), court.Booked, court.Lost)
+			}
+		})
+		return
+	}
+	out.print(HistoryResult{Attempts: attempts}, func() {
+		for _, attempt := range attempts {
+			result := fmt.Sprintf("booked %d", attempt.BookingID)
+			if !attempt.Booked {
+				result = "failed: " + attempt.Failure
+			}
+			fmt.Printf("%s\t%s\t%s %s %s\t%s\t%s\n", attempt.AttemptedAt.Format("2006-01-02 15:04:05"), attempt.Source, attempt.Date, attempt.Day, attempt.Time, firstOf(attempt.Court, "any"), result)
+		}
+	})
+}
+
+func serve(out printer, config *LISConfig, instance *Client, history *History) {
+	if config.token == "" {
+		token := make([]byte, 16)
+		_, err := rand.Read(token)
+		if err != nil {
+			out.fail(1, "Failed to generate the API token: %s", err)
+		}
+		config.token = hex.EncodeToString(token)
+		fmt.Fprintf(os.Stderr, "Generated API token: %s\n", config.token)
+	}
+	server, err := NewServer(instance, config.token)
+	if err != nil {
+		out.fail(1, "Failed to start the server: %s", err)
+	}
+	server.Courts = config.courts
+	server.Description = config.details
+	server.Schedule().SetHistory(history, "serve")
+	server.Schedule().SetRules(config.rules)
+	logAt(LevelInfo, "serving the API", F("listen", config.listen))
+	err = server.ListenAndServe(config.listen)
+	if err != nil {
+		out.fail(1, "Server failed: %s", err)
+	}
+}
+
+// setTransport records or replays the traffic with the API if asked.
+func setTransport(out printer, config *LISConfig, instance *Client) {
+	switch {
+	case config.replay != "":
+		replayer, err := NewReplayer(config.replay)
+		if err != nil {
+			out.fail(1, "Failed to load the cassette: %s", err)
+		}
+		instance.SetTransport(replayer)
+	case config.record != "":
+		recorder := NewRecorder(config.record, nil)
+		recorder.Secrets = []string{instance.password}
+		instance.SetTransport(recorder)
+	}
+}
+
+// newLedger keeps the ledger next to the history, it's disabled with the
+// history.
+func newLedger(config *LISConfig) *BookingLedger {
+	if config.history == "" {
+		return nil
 	}
+	return NewBookingLedger(filepath.Join(filepath.Dir(config.history), "ledger.json"))
 }
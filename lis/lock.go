@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package lis
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile waits for the exclusive lock of the file. The lock is released
+// by unlockFile or when the process exits, so a crash never leaves it held.
+func lockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+}
+
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
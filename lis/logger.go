@@ -0,0 +1,171 @@
+package lis
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelOff
+)
+
+var levelNames = []string{"debug", "info", "warn", "error", "off"}
+
+func (level Level) String() string {
+	if level < LevelDebug || level > LevelOff {
+		return fmt.Sprintf("level(%d)", int(level))
+	}
+	return levelNames[level]
+}
+
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(level), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %s", name)
+}
+
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Logger receives the messages of the library. The fields are already
+// redacted when they get here.
+type Logger interface {
+	Log(level Level, message string, fields ...Field)
+}
+
+// DefaultLogger is used by the clients without their own logger and by
+// the code which has no client at hand.
+var DefaultLogger Logger = NewLogger(os.Stderr, LevelInfo, false)
+
+type streamLogger struct {
+	out   io.Writer
+	level Level
+	json  bool
+	lock  sync.Mutex
+}
+
+// NewLogger writes the messages of the level and above to out, either as
+// "time level message key=value" lines or as JSON objects, one per line.
+func NewLogger(out io.Writer, level Level, asJSON bool) Logger {
+	return &streamLogger{out: out, level: level, json: asJSON}
+}
+
+func (logger *streamLogger) Log(level Level, message string, fields ...Field) {
+	if level < logger.level || logger.level == LevelOff {
+		return
+	}
+	var line bytes.Buffer
+	now := time.Now().Format(time.RFC3339)
+	if logger.json {
+		line.WriteString("{")
+		writeJSONField(&line, "time", now)
+		line.WriteString(",")
+		writeJSONField(&line, "level", level.String())
+		line.WriteString(",")
+		writeJSONField(&line, "msg", message)
+		for _, field := range fields {
+			line.WriteString(",")
+			writeJSONField(&line, field.Key, field.Value)
+		}
+		line.WriteString("}\n")
+	} else {
+		fmt.Fprintf(&line, "%s %-5s %s", now, strings.ToUpper(level.String()), message)
+		for _, field := range fields {
+			value := fmt.Sprint(field.Value)
+			// quoted, so a value can't break the line or fake a field
+			if value == "" || strings.ContainsAny(value, " \"=\n\r") {
+				value = fmt.Sprintf("%q", value)
+			}
+			fmt.Fprintf(&line, " %s=%s", field.Key, value)
+		}
+		line.WriteString("\n")
+	}
+	logger.lock.Lock()
+	defer logger.lock.Unlock()
+	logger.out.Write(line.Bytes())
+}
+
+func writeJSONField(line *bytes.Buffer, key string, value interface{}) {
+	encodedKey, _ := json.Marshal(key)
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		encodedValue, _ = json.Marshal(fmt.Sprint(value))
+	}
+	line.Write(encodedKey)
+	line.WriteString(":")
+	line.Write(encodedValue)
+}
+
+const redacted = "[REDACTED]"
+
+var sensitiveKeys = []string{"password", "cookie", "token", "secret", "authorization"}
+
+func sensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactSecrets(text string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			text = strings.ReplaceAll(text, secret, redacted)
+		}
+	}
+	return text
+}
+
+// emit redacts the message and the fields before they reach the logger:
+// the fields with sensitive names are replaced completely, and the known
+// secrets, e.g. the password and the session cookie, anywhere in the text.
+func emit(logger Logger, level Level, message string, fields []Field, secrets []string) {
+	if logger == nil {
+		logger = DefaultLogger
+	}
+	clean := make([]Field, len(fields))
+	for index, field := range fields {
+		clean[index].Key = field.Key
+		switch value := field.Value.(type) {
+		case string:
+			clean[index].Value = redactSecrets(value, secrets)
+		case error:
+			clean[index].Value = redactSecrets(value.Error(), secrets)
+		default:
+			clean[index].Value = value
+		}
+		if sensitiveKey(field.Key) {
+			clean[index].Value = redacted
+		}
+	}
+	logger.Log(level, redactSecrets(message, secrets), clean...)
+}
+
+// logAt is for the code without a client at hand.
+func logAt(level Level, message string, fields ...Field) {
+	emit(DefaultLogger, level, message, fields, nil)
+}
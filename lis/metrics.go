@@ -0,0 +1,176 @@
+package lis
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// counter is a counter with labels, every combination of the label values
+// is a separate series.
+type counter struct {
+	name   string
+	help   string
+	labels []string
+	lock   sync.Mutex
+	values map[string]float64
+}
+
+func newCounter(name string, help string, labels ...string) *counter {
+	return &counter{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+func (c *counter) Inc(values ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.values[strings.Join(values, "\xff")]++
+}
+
+func (c *counter) write(w io.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(c.labels) == 0 && len(keys) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+	}
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelSet(c.labels, strings.Split(key, "\xff")), formatValue(c.values[key]))
+	}
+}
+
+type histogram struct {
+	name    string
+	help    string
+	buckets []float64
+	lock    sync.Mutex
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(name string, help string, buckets ...float64) *histogram {
+	return &histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) Observe(value float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for index, bound := range h.buckets {
+		if value <= bound {
+			h.counts[index]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for index, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatValue(bound), h.counts[index])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatValue(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+func labelSet(names []string, values []string) string {
+	pairs := make([]string, 0, len(names))
+	for index, name := range names {
+		value := ""
+		if index < len(values) {
+			value = values[index]
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, value))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// metricSet holds the counters and histograms of the process. They are
+// global since Get and Post are instrumented and those are plain functions.
+type metricSet struct {
+	httpRequests     *counter
+	bookingAttempts  *counter
+	bookingSuccesses *counter
+	bookingFailures  *counter
+	reauths          *counter
+	refreshSeconds   *histogram
+	releaseLatency   *histogram
+}
+
+var metrics = metricSet{
+	httpRequests:     newCounter("lis_http_requests_total", "Requests to the API by method, endpoint and status.", "method", "endpoint", "status"),
+	bookingAttempts:  newCounter("lis_booking_attempts_total", "Attempts to book a slot by source.", "source"),
+	bookingSuccesses: newCounter("lis_booking_successes_total", "Booked slots by source.", "source"),
+	bookingFailures:  newCounter("lis_booking_failures_total", "Failed attempts to book a slot by source.", "source"),
+	reauths:          newCounter("lis_session_reauth_total", "Authorisations after the session has expired."),
+	refreshSeconds:   newHistogram("lis_schedule_refresh_seconds", "Duration of the schedule refresh.", 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10),
+	releaseLatency:   newHistogram("lis_booking_release_latency_seconds", "Time from the release of the slot to its booking.", 0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 30, 60),
+}
+
+// endpointLabel drops the IDs and dates from the path, so the label has a
+// value per kind of request, e.g. bookings/week for bookings/week/2022/11/29.
+func endpointLabel(handler string) string {
+	handler = strings.SplitN(handler, "?", 2)[0]
+	segments := make([]string, 0)
+	for _, segment := range strings.Split(handler, "/") {
+		_, err := strconv.Atoi(segment)
+		if segment != "" && err != nil {
+			segments = append(segments, segment)
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func countRequest(method string, handler string, code int, err error) {
+	status := strconv.Itoa(code)
+	if err != nil && code == 0 {
+		status = "error"
+	}
+	metrics.httpRequests.Inc(method, endpointLabel(handler), status)
+}
+
+func observeSince(h *histogram, start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+func WriteMetrics(w io.Writer) {
+	metrics.httpRequests.write(w)
+	metrics.bookingAttempts.write(w)
+	metrics.bookingSuccesses.write(w)
+	metrics.bookingFailures.write(w)
+	metrics.reauths.write(w)
+	metrics.refreshSeconds.write(w)
+	metrics.releaseLatency.write(w)
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format.
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteMetrics(w)
+	})
+}
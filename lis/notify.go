@@ -0,0 +1,163 @@
+package lis
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	EventBookingSucceeded = "booking.succeeded"
+	EventBookingFailed    = "booking.failed"
+	EventBookingCancelled = "booking.cancelled"
+	EventSlotFreed        = "slot.freed"
+	EventSessionReauth    = "session.reauth"
+)
+
+// Event is what the notifiers send. Only the fields known for the type of
+// the event are set. For is the username of the teammate the booking is
+// made for, empty for my own bookings.
+type Event struct {
+	Type     string    `json:"type"`
+	At       time.Time `json:"at"`
+	Username string    `json:"username,omitempty"`
+	Job      string    `json:"job,omitempty"`
+	Date     string    `json:"date,omitempty"`
+	Day      string    `json:"day,omitempty"`
+	Time     string    `json:"time,omitempty"`
+	Court    string    `json:"court,omitempty"`
+	For      string    `json:"for,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type Notifier interface {
+	Notify(event Event) error
+}
+
+// Notifiers sends the event to all of them. A failing notifier doesn't stop
+// the others, the errors of all of them are returned together.
+type Notifiers []Notifier
+
+func (notifiers Notifiers) Notify(event Event) error {
+	failures := make([]string, 0)
+	for _, notifier := range notifiers {
+		err := notifier.Notify(event)
+		if err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// Webhook POSTs the event as JSON. The body is signed with HMAC-SHA256 of
+// the secret in the X-LIS-Signature header, "sha256=<hex>", so the receiver
+// can check the sender. Network errors and 5xx responses are retried.
+type Webhook struct {
+	URL      string
+	Secret   string
+	Events   []string
+	Attempts int
+	Retry    time.Duration
+	client   *http.Client
+}
+
+func NewWebhook(url string, secret string) *Webhook {
+	return &Webhook{
+		URL:      url,
+		Secret:   secret,
+		Attempts: 3,
+		Retry:    time.Second,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Sign returns the value of the signature header for the body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (webhook *Webhook) wanted(eventType string) bool {
+	if len(webhook.Events) == 0 {
+		return true
+	}
+	for _, wanted := range webhook.Events {
+		if wanted == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func (webhook *Webhook) Notify(event Event) error {
+	if !webhook.wanted(event.Type) {
+		return nil
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		retry, err := webhook.send(event.Type, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= webhook.Attempts {
+			return fmt.Errorf("webhook %s: %s", webhook.URL, err.Error())
+		}
+		logAt(LevelWarn, "webhook attempt failed", F("url", webhook.URL), F("attempt", attempt), F("attempts", webhook.Attempts), F("error", err))
+		time.Sleep(webhook.Retry * time.Duration(attempt))
+	}
+}
+
+// send makes a single delivery and tells if it's worth to retry it.
+func (webhook *Webhook) send(eventType string, body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-LIS-Event", eventType)
+	if webhook.Secret != "" {
+		req.Header.Set("X-LIS-Signature", Sign(webhook.Secret, body))
+	}
+	resp, err := webhook.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return false, nil
+}
+
+func outcomeEvent(outcome JobOutcome) Event {
+	event := Event{
+		Type:  EventBookingSucceeded,
+		At:    time.Now(),
+		Job:   outcome.Job,
+		Date:  outcome.Date,
+		Day:   outcome.Day,
+		Time:  outcome.Time,
+		Court: outcome.Court,
+		For:   outcome.For,
+	}
+	if !outcome.Booked {
+		event.Type = EventBookingFailed
+		event.Error = outcome.Error
+	}
+	return event
+}
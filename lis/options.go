@@ -0,0 +1,80 @@
+package lis
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Option changes the client made by NewInstance.
+type Option func(*Client)
+
+// WithHTTPClient sends the requests with a copy of the client, e.g. to set
+// its timeout. The cookie jar of the http.Client is replaced by the one of
+// the client.
+func WithHTTPClient(client *http.Client) Option {
+	return func(inst *Client) {
+		inst.client = client
+	}
+}
+
+// WithTransport sends the requests through the transport, e.g. a Recorder.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(inst *Client) {
+		inst.transport = transport
+	}
+}
+
+// WithBaseURL replaces the endpoint, it may have a path prefix, e.g.
+// https://club.example/api/v1.
+func WithBaseURL(base string) Option {
+	return func(inst *Client) {
+		inst.endpoint = strings.TrimRight(base, "/")
+	}
+}
+
+func WithTLSConfig(config *tls.Config) Option {
+	return func(inst *Client) {
+		inst.tlsConfig = config
+	}
+}
+
+func WithProxy(proxy *url.URL) Option {
+	return func(inst *Client) {
+		inst.proxy = proxy
+	}
+}
+
+func WithUserAgent(agent string) Option {
+	return func(inst *Client) {
+		inst.userAgent = agent
+	}
+}
+
+// WithHeader adds the header to every request.
+func WithHeader(name string, value string) Option {
+	return func(inst *Client) {
+		inst.headers.Add(name, value)
+	}
+}
+
+func WithLogger(logger Logger) Option {
+	return func(inst *Client) {
+		inst.logger = logger
+	}
+}
+
+// API sends the requests of the schedule and returns the status and the
+// body of the response. The client is the API of its schedules; a mock or
+// a middleware wrapping the client may replace it with Schedule.SetAPI.
+type API interface {
+	Request(method string, handler string, payload []byte) (int, []byte, error)
+}
+
+// APIFunc is a function used as API, like http.HandlerFunc.
+type APIFunc func(method string, handler string, payload []byte) (int, []byte, error)
+
+func (api APIFunc) Request(method string, handler string, payload []byte) (int, []byte, error) {
+	return api(method, handler, payload)
+}
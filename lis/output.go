@@ -0,0 +1,89 @@
+package lis
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+var outputFormats = []string{"text", "json", "yaml"}
+
+type LoginResult struct {
+	Username string `json:"username" yaml:"username"`
+	UserID   uint64 `json:"user_id" yaml:"user_id"`
+	GroupID  uint64 `json:"group_id" yaml:"group_id"`
+}
+
+type ScheduleResult struct {
+	TimeTables []TimeTable `json:"timetables" yaml:"timetables"`
+}
+
+// BookingResult is the outcome of a booking. AlreadyHeld means nothing was
+// booked since the slot was mine already, Court is that booking's.
+type BookingResult struct {
+	Booked      bool             `json:"booked" yaml:"booked"`
+	AlreadyHeld bool             `json:"already_held,omitempty" yaml:"already_held,omitempty"`
+	Date        string           `json:"date,omitempty" yaml:"date,omitempty"`
+	Court       string           `json:"court" yaml:"court"`
+	Day         string           `json:"day" yaml:"day"`
+	Time        string           `json:"time" yaml:"time"`
+	Description string           `json:"description" yaml:"description"`
+	For         string           `json:"for,omitempty" yaml:"for,omitempty"`
+	DryRun      bool             `json:"dry_run,omitempty" yaml:"dry_run,omitempty"`
+	Requests    []PlannedRequest `json:"requests,omitempty" yaml:"requests,omitempty"`
+}
+
+type CancelResult struct {
+	ID        int              `json:"id" yaml:"id"`
+	Cancelled bool             `json:"cancelled" yaml:"cancelled"`
+	DryRun    bool             `json:"dry_run,omitempty" yaml:"dry_run,omitempty"`
+	Requests  []PlannedRequest `json:"requests,omitempty" yaml:"requests,omitempty"`
+}
+
+type BookingsResult struct {
+	Bookings []BookingDetails `json:"bookings" yaml:"bookings"`
+}
+
+type PlanResult struct {
+	Games []PlannedGame `json:"games" yaml:"games"`
+}
+
+type HistoryResult struct {
+	Attempts []Attempt `json:"attempts" yaml:"attempts"`
+}
+
+type ErrorResult struct {
+	Error string `json:"error" yaml:"error"`
+}
+
+type printer struct {
+	format string
+}
+
+// print writes the document in the selected format. For the text format
+// the text callback is used instead, so every command keeps its own layout.
+func (p printer) print(document interface{}, text func()) {
+	switch p.format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(document)
+	case "yaml":
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		encoder.Encode(document)
+		encoder.Close()
+	default:
+		text()
+	}
+}
+
+func (p printer) fail(code int, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	p.print(ErrorResult{Error: message}, func() {
+		fmt.Println(message)
+	})
+	os.Exit(code)
+}
@@ -0,0 +1,142 @@
+package lis
+
+import (
+	"fmt"
+	"time"
+)
+
+// searchHorizon limits how far a recurrence is expanded when it has no
+// matching dates left, e.g. all of them are skipped.
+const searchHorizon = 5 * 366
+
+// Recurrence describes the dates of the games: the given weekdays of every
+// Every-th week counted from Start, up to Until, except the Skip dates.
+type Recurrence struct {
+	Days  []string `yaml:"days"`
+	Every int      `yaml:"every"`
+	Start string   `yaml:"start"`
+	Until string   `yaml:"until"`
+	Skip  []string `yaml:"skip"`
+
+	weekdays map[time.Weekday]bool
+	start    time.Time
+	until    time.Time
+	skip     map[string]bool
+}
+
+func parseDate(name string, value string) (time.Time, error) {
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s %s should have a format YYYY-MM-DD", name, value)
+	}
+	return date, nil
+}
+
+func weekdayOf(day string) (time.Weekday, error) {
+	for index, name := range dayNames {
+		if name == day {
+			return time.Weekday(index), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown day %s", day)
+}
+
+func (rule *Recurrence) Prepare() error {
+	if len(rule.Days) == 0 {
+		return fmt.Errorf("days are not set")
+	}
+	rule.weekdays = make(map[time.Weekday]bool)
+	for _, day := range rule.Days {
+		weekday, err := weekdayOf(day)
+		if err != nil {
+			return err
+		}
+		rule.weekdays[weekday] = true
+	}
+	if rule.Every <= 0 {
+		rule.Every = 1
+	}
+	var err error
+	if rule.Start != "" {
+		rule.start, err = parseDate("start", rule.Start)
+		if err != nil {
+			return err
+		}
+	} else if rule.Every > 1 {
+		return fmt.Errorf("start is required to count every %d weeks", rule.Every)
+	}
+	if rule.Until != "" {
+		rule.until, err = parseDate("until", rule.Until)
+		if err != nil {
+			return err
+		}
+	}
+	rule.skip = make(map[string]bool)
+	for _, skip := range rule.Skip {
+		_, err = parseDate("skip", skip)
+		if err != nil {
+			return err
+		}
+		rule.skip[skip] = true
+	}
+	return nil
+}
+
+// dayNumber counts the days since the epoch ignoring the time and timezone.
+func dayNumber(date time.Time) int {
+	return int(time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
+
+func (rule *Recurrence) Matches(date time.Time) bool {
+	if !rule.weekdays[date.Weekday()] {
+		return false
+	}
+	if !rule.start.IsZero() && dayNumber(date) < dayNumber(rule.start) {
+		return false
+	}
+	if !rule.until.IsZero() && dayNumber(date) > dayNumber(rule.until) {
+		return false
+	}
+	if rule.skip[date.Format("2006-01-02")] {
+		return false
+	}
+	if rule.Every > 1 {
+		// weeks are counted from the monday of the start week
+		monday := dayNumber(rule.start) - (int(rule.start.Weekday())+6)%7
+		if ((dayNumber(date)-monday)/7)%rule.Every != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// NextDate returns the first matching date not earlier than from. False is
+// returned when the recurrence is over.
+func (rule *Recurrence) NextDate(from time.Time) (time.Time, bool) {
+	date := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	for shift := 0; shift < searchHorizon; shift++ {
+		if !rule.until.IsZero() && dayNumber(date) > dayNumber(rule.until) {
+			return time.Time{}, false
+		}
+		if rule.Matches(date) {
+			return date, true
+		}
+		date = date.AddDate(0, 0, 1)
+	}
+	return time.Time{}, false
+}
+
+// Occurrences expands the recurrence into at most count dates starting from
+// the given day.
+func (rule *Recurrence) Occurrences(from time.Time, count int) []time.Time {
+	dates := make([]time.Time, 0, count)
+	for len(dates) < count {
+		date, ok := rule.NextDate(from)
+		if !ok {
+			break
+		}
+		dates = append(dates, date)
+		from = date.AddDate(0, 0, 1)
+	}
+	return dates
+}
@@ -0,0 +1,107 @@
+package lis
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	markFree   = "."
+	markBooked = "X"
+	markMine   = "*"
+
+	colourReset  = "\033[0m"
+	colourGreen  = "\033[32m"
+	colourRed    = "\033[31m"
+	colourYellow = "\033[33m"
+)
+
+type GridOptions struct {
+	Court    string
+	Day      string
+	FreeOnly bool
+	Colour   bool
+}
+
+func cellMark(cell TimeTableCell, opts GridOptions) string {
+	mark, colour := markFree, colourGreen
+	if cell.Mine {
+		mark, colour = markMine, colourYellow
+	} else if cell.Booked {
+		mark, colour = markBooked, colourRed
+	}
+	if opts.FreeOnly && cell.Booked {
+		return ""
+	}
+	if opts.Colour {
+		return colour + mark + colourReset
+	}
+	return mark
+}
+
+// WriteGrid prints every time table as a grid with days as columns and time
+// slots as rows. Slots which don't exist on a day are left blank.
+func WriteGrid(w io.Writer, tables []TimeTable, opts GridOptions) {
+	for _, table := range tables {
+		if opts.Court != "" && table.Name != opts.Court {
+			continue
+		}
+		days := make([]TimeTableDay, 0, len(table.Days))
+		for _, day := range table.Days {
+			if opts.Day == "" || day.Day == opts.Day {
+				days = append(days, day)
+			}
+		}
+
+		rows := make([]string, 0)
+		cells := make(map[string]map[string]TimeTableCell)
+		width := len("Time")
+		for _, day := range days {
+			for _, cell := range day.Cells {
+				if _, ok := cells[cell.Time]; !ok {
+					cells[cell.Time] = make(map[string]TimeTableCell)
+					rows = append(rows, cell.Time)
+					if len(cell.Time) > width {
+						width = len(cell.Time)
+					}
+				}
+				cells[cell.Time][day.Day] = cell
+			}
+		}
+
+		fmt.Fprintf(w, "%s\n", table.Name)
+		fmt.Fprintf(w, "%-*s", width, "Time")
+		for _, day := range days {
+			fmt.Fprintf(w, " %4s", day.Day)
+		}
+		fmt.Fprintln(w)
+		for _, row := range rows {
+			line := make([]string, 0, len(days))
+			free := false
+			for _, day := range days {
+				cell, ok := cells[row][day.Day]
+				mark := ""
+				if ok {
+					mark = cellMark(cell, opts)
+					free = free || !cell.Booked
+				}
+				// ANSI codes don't take space on the terminal, so pad by the mark only
+				line = append(line, strings.Repeat(" ", 5-len(stripColour(mark)))+mark)
+			}
+			if opts.FreeOnly && !free {
+				continue
+			}
+			fmt.Fprintf(w, "%-*s%s\n", width, row, strings.Join(line, ""))
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%s free  %s booked  %s mine\n", markFree, markBooked, markMine)
+}
+
+func stripColour(mark string) string {
+	for _, code := range []string{colourReset, colourGreen, colourRed, colourYellow} {
+		mark = strings.ReplaceAll(mark, code, "")
+	}
+	return mark
+}
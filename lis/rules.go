@@ -0,0 +1,123 @@
+package lis
+
+import (
+	"fmt"
+	"time"
+)
+
+// Rules are the booking policy of the club the schedule keeps to, so the
+// automation never books what would get the account suspended. Zero values
+// disable the rules.
+type Rules struct {
+	// MaxPerWeek is the number of bookings I may hold within a week.
+	MaxPerWeek int `yaml:"max_per_week"`
+	// MaxPrime is the number of prime time slots I may hold within a week.
+	MaxPrime int `yaml:"max_prime"`
+	// NoBackToBack forbids booking a slot next to one I already have that
+	// day, on any court.
+	NoBackToBack bool `yaml:"no_back_to_back"`
+	// MinNotice is how long before its start a slot may still be booked,
+	// e.g. "2h".
+	MinNotice    string   `yaml:"min_notice"`
+	BannedCourts []string `yaml:"banned_courts"`
+
+	notice time.Duration
+}
+
+// RuleViolation tells which of the rules stops the booking and why.
+type RuleViolation struct {
+	Rule   string
+	Reason string
+}
+
+func (violation RuleViolation) Error() string {
+	return fmt.Sprintf("rule %s: %s", violation.Rule, violation.Reason)
+}
+
+func (rules *Rules) Prepare() error {
+	if rules.MaxPerWeek < 0 || rules.MaxPrime < 0 {
+		return fmt.Errorf("rules: limits can not be negative")
+	}
+	if rules.MinNotice == "" {
+		return nil
+	}
+	notice, err := time.ParseDuration(rules.MinNotice)
+	if err != nil {
+		return fmt.Errorf("rules: %s", err.Error())
+	}
+	if notice < 0 {
+		return fmt.Errorf("rules: min_notice can not be negative")
+	}
+	rules.notice = notice
+	return nil
+}
+
+// SetRules makes the schedule check the rules before booking a cell, the
+// cells breaking them are skipped. Nil rules allow everything.
+func (sched *Schedule) SetRules(rules *Rules) {
+	sched.rules = rules
+}
+
+// checkRules tells whether the rules allow the booker to book the time slot
+// on the court for the date, the bookings of the refreshed week are taken
+// into account.
+func (sched *Schedule) checkRules(bookerID uint64, date time.Time, court string, timeSlotID int) error {
+	rules := sched.rules
+	if rules == nil {
+		return nil
+	}
+	for _, banned := range rules.BannedCourts {
+		if banned == court {
+			return RuleViolation{Rule: "banned_courts", Reason: fmt.Sprintf("court %s is banned", court)}
+		}
+	}
+	timeSlots := make(map[int]TimeSlot)
+	for _, timeSlot := range sched.timeSlots {
+		timeSlots[timeSlot.ID] = timeSlot
+	}
+	slot := timeSlots[timeSlotID]
+	dates := make(map[int]string)
+	for _, bookedTimeSlot := range sched.booked_time_slots {
+		dates[bookedTimeSlot.ID] = bookedTimeSlot.BookingDate
+	}
+
+	mine, prime := 0, 0
+	for _, booking := range sched.bookings {
+		if uint64(booking.BookedByUserID) != bookerID {
+			continue
+		}
+		mine++
+		booked, ok := timeSlots[sched.bts2ts[booking.BookedTimeSlotID]]
+		if !ok {
+			continue
+		}
+		if booked.Prime {
+			prime++
+		}
+		sameDay := dates[booking.BookedTimeSlotID] == date.Format("2006-01-02")
+		if rules.NoBackToBack && sameDay && (booked.SequenceNum == slot.SequenceNum-1 || booked.SequenceNum == slot.SequenceNum+1) {
+			return RuleViolation{Rule: "no_back_to_back", Reason: fmt.Sprintf("%s is next to my booking at %s", slot.Description, booked.Description)}
+		}
+	}
+	if rules.MaxPerWeek > 0 && mine >= rules.MaxPerWeek {
+		return RuleViolation{Rule: "max_per_week", Reason: fmt.Sprintf("%d bookings of %d are held this week", mine, rules.MaxPerWeek)}
+	}
+	if rules.MaxPrime > 0 && slot.Prime && prime >= rules.MaxPrime {
+		return RuleViolation{Rule: "max_prime", Reason: fmt.Sprintf("%d prime slots of %d are held this week", prime, rules.MaxPrime)}
+	}
+	if rules.notice > 0 {
+		start, _, err := ParseSlotTime(slot.Description)
+		if err != nil {
+			return RuleViolation{Rule: "min_notice", Reason: err.Error()}
+		}
+		location, err := sched.Location()
+		if err != nil {
+			return RuleViolation{Rule: "min_notice", Reason: err.Error()}
+		}
+		starts := clockOn(date, start, location)
+		if time.Until(starts) < rules.notice {
+			return RuleViolation{Rule: "min_notice", Reason: fmt.Sprintf("%s starts in less than %s", starts.Format("2006-01-02 15:04"), rules.notice)}
+		}
+	}
+	return nil
+}
@@ -4,8 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"os"
+	"strings"
 	"time"
 )
 
@@ -15,29 +15,71 @@ type Schedule struct {
 	timeSlots         []TimeSlot
 	bookings          []Boooking
 	booked_time_slots []BookedTimeSlot
-	session           *instance
+	session           *Client
 	bts2ts            map[int]int
 	renderedData      []TimeTable
+	history           *History
+	source            string
+	logger            Logger
+	api               API
+	dryRun            bool
+	planned           []PlannedRequest
+	ledger            *BookingLedger
+	anyCourtGuard     bool
+	rules             *Rules
+	bookFor           string
+	group             *Group
+	groupRead         bool
+	location          *time.Location
+	readAt            time.Time
+	held              bool
+}
+
+// PlannedRequest is a request the dry run would have sent. Note tells which
+// values of the payload are placeholders for the ones only the responses
+// of the previous requests would give.
+type PlannedRequest struct {
+	Method   string `json:"method" yaml:"method"`
+	Endpoint string `json:"endpoint" yaml:"endpoint"`
+	Payload  string `json:"payload" yaml:"payload"`
+	Note     string `json:"note,omitempty" yaml:"note,omitempty"`
 }
 
 type TimeTableCell struct {
-	Time   string
-	Booked bool
-	ID     int
+	Time        string `json:"time" yaml:"time"`
+	Booked      bool   `json:"booked" yaml:"booked"`
+	Mine        bool   `json:"mine" yaml:"mine"`
+	ID          int    `json:"id" yaml:"id"`
+	BookingID   int    `json:"booking_id,omitempty" yaml:"booking_id,omitempty"`
+	Booker      string `json:"booker,omitempty" yaml:"booker,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
 }
 
 type TimeTableDay struct {
-	Day   string
-	Cells []TimeTableCell
+	Day   string          `json:"day" yaml:"day"`
+	Date  string          `json:"date" yaml:"date"`
+	Cells []TimeTableCell `json:"cells" yaml:"cells"`
 }
 
 type TimeTable struct {
-	Name string
-	ID   int
-	Days []TimeTableDay
+	Name string         `json:"name" yaml:"name"`
+	ID   int            `json:"id" yaml:"id"`
+	Days []TimeTableDay `json:"days" yaml:"days"`
+}
+
+type BookingDetails struct {
+	ID          int    `json:"id" yaml:"id"`
+	Court       string `json:"court" yaml:"court"`
+	Date        string `json:"date" yaml:"date"`
+	Day         string `json:"day" yaml:"day"`
+	Time        string `json:"time" yaml:"time"`
+	Booker      string `json:"booker,omitempty" yaml:"booker,omitempty"`
+	Description string `json:"description" yaml:"description"`
 }
 
-func NewSchedule(session *instance) (*Schedule, error) {
+var dayNames = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+func NewSchedule(session *Client) (*Schedule, error) {
 	if session == nil {
 		return nil, errors.New("bad session pointer")
 	}
@@ -46,6 +88,7 @@ func NewSchedule(session *instance) (*Schedule, error) {
 	}
 	sch := Schedule{
 		session: session,
+		api:     session,
 	}
 	return &sch, nil
 }
@@ -57,14 +100,42 @@ func (sched *Schedule) makeBTS2TSMap() {
 	}
 }
 
+// Refresh reads the users, courts, time slots and bookings of the week. The
+// first failed request is returned and the schedule read before is kept, so
+// the callers decide whether to retry or give up instead of the process
+// exiting.
 func (sched *Schedule) Refresh() error {
-	sched.users = sched.getUsers()
-	sched.resources = sched.getResources()
-	sched.timeSlots = sched.getTimeSlots()
-	sched.bookings = sched.getBookings()
-	sched.booked_time_slots = sched.getBookedTimeSlots()
+	started := time.Now()
+	defer observeSince(metrics.refreshSeconds, started)
+	users, err := sched.getUsers()
+	if err != nil {
+		return err
+	}
+	resources, err := sched.getResources()
+	if err != nil {
+		return err
+	}
+	timeSlots, err := sched.getTimeSlots()
+	if err != nil {
+		return err
+	}
+	bookings, err := sched.getBookings()
+	if err != nil {
+		return err
+	}
+	bookedTimeSlots, err := sched.getBookedTimeSlots()
+	if err != nil {
+		return err
+	}
+	sched.users = users
+	sched.resources = resources
+	sched.timeSlots = timeSlots
+	sched.bookings = bookings
+	sched.booked_time_slots = bookedTimeSlots
 
 	sched.makeBTS2TSMap()
+	sched.renderedData = nil
+	sched.readAt = started
 	return nil
 }
 
@@ -85,46 +156,38 @@ func (sched *Schedule) RenderSchedule() []TimeTable {
 
 	}
 
-	bookedMask := make(map[string]bool)
+	bookedMask := make(map[string]Boooking)
 
 	for _, booking := range sched.bookings {
 		timeSlotID := sched.bts2ts[booking.BookedTimeSlotID]
 		mask := fmt.Sprintf("%d:%d", booking.ResourceID, timeSlotID)
-		bookedMask[mask] = true
+		bookedMask[mask] = booking
 	}
 
 	for index, res := range schedule {
 		schedule[index].Days = make([]TimeTableDay, 7)
-		schedule[index].Days[0].Day = "Sun"
-		schedule[index].Days[0].Cells = make([]TimeTableCell, 0)
-
-		schedule[index].Days[1].Day = "Mon"
-		schedule[index].Days[1].Cells = make([]TimeTableCell, 0)
-
-		schedule[index].Days[2].Day = "Tue"
-		schedule[index].Days[2].Cells = make([]TimeTableCell, 0)
-
-		schedule[index].Days[3].Day = "Wed"
-		schedule[index].Days[3].Cells = make([]TimeTableCell, 0)
-
-		schedule[index].Days[4].Day = "Thu"
-		schedule[index].Days[4].Cells = make([]TimeTableCell, 0)
-
-		schedule[index].Days[5].Day = "Fri"
-		schedule[index].Days[5].Cells = make([]TimeTableCell, 0)
-
-		schedule[index].Days[6].Day = "Sat"
-		schedule[index].Days[6].Cells = make([]TimeTableCell, 0)
+		for day, name := range dayNames {
+			schedule[index].Days[day].Day = name
+			schedule[index].Days[day].Date = sched.dateOf(name).Format("2006-01-02")
+			schedule[index].Days[day].Cells = make([]TimeTableCell, 0)
+		}
 
 		for _, time_slot := range sched.timeSlots {
 			mask := fmt.Sprintf("%d:%d", res.ID, time_slot.ID)
-			_, ok := bookedMask[mask]
+			booking, ok := bookedMask[mask]
 			if ok {
-				schedule[index].Days[time_slot.DayOfWeek-1].Cells = append(schedule[index].Days[time_slot.DayOfWeek-1].Cells, TimeTableCell{
-					Time:   time_slot.Description,
-					Booked: true,
-					ID:     time_slot.ID,
-				})
+				cell := TimeTableCell{
+					Time:        time_slot.Description,
+					Booked:      true,
+					Mine:        uint64(booking.BookedByUserID) == sched.session.GetUserId(),
+					ID:          time_slot.ID,
+					Booker:      sched.userName(booking.BookedByUserID),
+					Description: booking.Description,
+				}
+				if cell.Mine {
+					cell.BookingID = booking.ID
+				}
+				schedule[index].Days[time_slot.DayOfWeek-1].Cells = append(schedule[index].Days[time_slot.DayOfWeek-1].Cells, cell)
 			} else {
 				schedule[index].Days[time_slot.DayOfWeek-1].Cells = append(schedule[index].Days[time_slot.DayOfWeek-1].Cells, TimeTableCell{
 					Time:   time_slot.Description,
@@ -140,8 +203,123 @@ func (sched *Schedule) RenderSchedule() []TimeTable {
 	// TODO: booked_time_slot_id is not ID of time_slot, so, at first we need to request booked_time_slots and find there time_slot_id
 }
 
-func (sched *Schedule) bookTimeSlot(timeSlotID int, resourceID int, description string) int {
-	date := sched.getDate()
+// SetHistory makes the schedule record every booking attempt into the
+// history on behalf of the source, e.g. the command name.
+func (sched *Schedule) SetHistory(history *History, source string) {
+	sched.history = history
+	sched.source = source
+}
+
+// SetDryRun makes the schedule resolve the cells and log the requests it
+// would POST to book them, or DELETE to cancel them, without sending those.
+// The bookings look successful, they are not recorded into the history or
+// the metrics and no events are sent.
+func (sched *Schedule) SetDryRun(dryRun bool) {
+	sched.dryRun = dryRun
+}
+
+func (sched *Schedule) DryRun() bool {
+	return sched.dryRun
+}
+
+// PlannedRequests returns the requests of the dry run since the last call.
+func (sched *Schedule) PlannedRequests() []PlannedRequest {
+	planned := sched.planned
+	sched.planned = nil
+	return planned
+}
+
+// Client returns the session of the schedule.
+func (sched *Schedule) Client() *Client {
+	return sched.session
+}
+
+// SetAPI makes the schedule send its requests through the api instead of
+// its session, e.g. a mock or a middleware wrapping API().
+func (sched *Schedule) SetAPI(api API) {
+	sched.api = api
+}
+
+func (sched *Schedule) API() API {
+	return sched.api
+}
+
+// SetLogger makes the schedule log to the logger instead of the one of its
+// session.
+func (sched *Schedule) SetLogger(logger Logger) {
+	sched.logger = logger
+}
+
+func (sched *Schedule) log(level Level, message string, fields ...Field) {
+	logger := sched.logger
+	if logger == nil {
+		logger = sched.session.logger
+	}
+	emit(logger, level, message, fields, sched.session.secrets())
+}
+
+func (sched *Schedule) record(attempt Attempt) {
+	if sched.dryRun {
+		return
+	}
+	metrics.bookingAttempts.Inc(sched.source)
+	if attempt.Booked {
+		metrics.bookingSuccesses.Inc(sched.source)
+	} else {
+		metrics.bookingFailures.Inc(sched.source)
+	}
+	if sched.history == nil {
+		return
+	}
+	attempt.Source = sched.source
+	err := sched.history.Record(attempt)
+	if err != nil {
+		sched.log(LevelWarn, "failed to record the attempt", F("error", err))
+	}
+}
+
+// timedPost is poster measuring the latency of the request into the attempt.
+// In the dry run the request is only logged and respobj is left empty.
+func (sched *Schedule) timedPost(attempt *Attempt, resname string, request_payload *[]byte, respobj interface{}) error {
+	if sched.dryRun {
+		planned := PlannedRequest{Method: "POST", Endpoint: resname, Payload: string(*request_payload)}
+		sched.planned = append(sched.planned, planned)
+		sched.log(LevelInfo, "dry run, request is not sent", F("method", planned.Method), F("endpoint", planned.Endpoint), F("payload", planned.Payload))
+		return nil
+	}
+	started := time.Now()
+	err := sched.poster(resname, request_payload, respobj)
+	if attempt.LatencyMS == nil {
+		attempt.LatencyMS = make(map[string]float64)
+	}
+	attempt.LatencyMS[resname] = float64(time.Since(started).Microseconds()) / 1000
+	if err != nil {
+		attempt.fail(fmt.Sprintf("%s: %s", resname, err.Error()))
+	}
+	return err
+}
+
+// bookTimeSlot posts the booked time slot and the booking holding the ledger,
+// so no other process books the slot meanwhile. The entry of the ledger
+// stays pending if the response of the booking is lost.
+func (sched *Schedule) bookTimeSlot(bookerID uint64, date time.Time, timeSlotID int, resourceID int, description string, attempt *Attempt) (int, error) {
+	entry := LedgerEntry{
+		Key:   idempotencyKey(bookerID, attempt.Date, attempt.Time),
+		Date:  attempt.Date,
+		Time:  attempt.Time,
+		Court: attempt.Court,
+	}
+	locked, err := sched.beginBooking(entry)
+	if err != nil {
+		attempt.fail(err.Error())
+		return -1, err
+	}
+	var kept *LedgerEntry
+	if locked {
+		defer func() {
+			sched.endBooking(entry.Key, kept)
+		}()
+	}
 	dateFormatedString := fmt.Sprintf("%d-%02d-%02d", date.Year(), date.Month(), date.Day())
 
 	timeSlotRequest := BookingTimeSlotRequest{
@@ -150,21 +328,21 @@ func (sched *Schedule) bookTimeSlot(timeSlotID int, resourceID int, description
 	}
 	payload, err := json.Marshal(timeSlotRequest)
 	if err != nil {
-		log.Printf("Error with request marshaling: %s", err.Error())
-		return -1
+		sched.log(LevelError, "can not marshal the request", F("endpoint", "booked_time_slots"), F("error", err))
+		return -1, err
 	}
 	var bookedTimeSlot BookingTimeSlotResponse
 
-	err = sched.poster("booked_time_slots", &payload, &bookedTimeSlot)
+	err = sched.timedPost(attempt, "booked_time_slots", &payload, &bookedTimeSlot)
 	if err != nil {
-		return -1
+		return -1, err
 	}
 
 	bookingTimeSlotRequest := BookingRequest{
 		ResourceID:           resourceID,
 		Description:          description,
 		BookedTimeSlotID:     bookedTimeSlot.ID,
-		BookedByUserID:       int(sched.session.userID),
+		BookedByUserID:       int(bookerID),
 		BookedWhen:           dateFormatedString,
 		SecondaryResourceIds: make([]interface{}, 0),
 		Ical:                 false,
@@ -172,116 +350,531 @@ func (sched *Schedule) bookTimeSlot(timeSlotID int, resourceID int, description
 
 	payload, err = json.Marshal(bookingTimeSlotRequest)
 	if err != nil {
-		log.Printf("Error with request marshaling: %s", err.Error())
-		return -1
+		sched.log(LevelError, "can not marshal the request", F("endpoint", "bookings"), F("error", err))
+		return -1, err
 	}
 	var bookingResponse BookingResponse
 
-	err = sched.poster("bookings", &payload, &bookingResponse)
+	err = sched.timedPost(attempt, "bookings", &payload, &bookingResponse)
+	if sched.dryRun {
+		sched.planned[len(sched.planned)-1].Note = "booked_time_slot_id 0 is a placeholder for the ID returned by the booked_time_slots request"
+	}
+	if _, rejected := err.(statusError); !rejected {
+		entry.Pending = err != nil
+		kept = &entry
+	}
 	if err != nil {
-		return -1
+		return -1, err
 	}
-
-	return bookingResponse.ID
+	entry.BookingID = bookingResponse.ID
+	attempt.BookingID = bookingResponse.ID
+	return bookingResponse.ID, nil
 }
 
 func (sched *Schedule) BookIfPossible(day string, time string, description string) *string {
+	return sched.BookCourtIfPossible("", day, time, description)
+}
+
+// BookCourtIfPossible books the first free cell matching day and time.
+// An empty court means any court is acceptable. If the slot is already
+// booked by me the court of that booking is returned.
+func (sched *Schedule) BookCourtIfPossible(court string, day string, slot string, description string) *string {
+	return sched.BookPreferredIfPossible([]string{court}, day, slot, description)
+}
+
+// newAttempt starts the attempt to book the slot of the day.
+func (sched *Schedule) newAttempt(day string, slot string) Attempt {
+	return Attempt{
+		Date:        sched.dateOf(day).Format("2006-01-02"),
+		Day:         day,
+		Time:        slot,
+		AttemptedAt: time.Now(),
+		For:         sched.bookFor,
+	}
+}
+
+// fail adds the reason to the failures of the attempt, once.
+func (attempt *Attempt) fail(reason string) {
+	for _, failure := range strings.Split(attempt.Failure, "; ") {
+		if failure == reason {
+			return
+		}
+	}
+	if attempt.Failure != "" {
+		reason = attempt.Failure + "; " + reason
+	}
+	attempt.Failure = reason
+}
+
+// bookCourt books the first free cell of the court like BookCourtIfPossible,
+// the try is added to the attempt which is left to the caller to record.
+// errBusy means another process books the slot, so the other courts are not
+// tried either.
+func (sched *Schedule) bookCourt(court string, day string, slot string, description string, attempt *Attempt) (*string, error) {
 	if sched.renderedData == nil {
 		sched.RenderSchedule()
 	}
+	date := sched.dateOf(day)
+	attempt.Courts = append(attempt.Courts, firstOf(court, "any"))
+	bookerID, err := sched.bookerID()
+	if err != nil {
+		sched.log(LevelError, "can not book for the user", F("for", sched.bookFor), F("error", err))
+		attempt.fail(err.Error())
+		return nil, nil
+	}
+	violations := make([]string, 0)
 	for _, resource := range sched.renderedData {
+		if court != "" && resource.Name != court {
+			continue
+		}
 		for _, dayCell := range resource.Days {
 			if dayCell.Day == day {
 				for _, timeCell := range dayCell.Cells {
-					if timeCell.Time == time && timeCell.Booked == false {
-						ret := sched.bookTimeSlot(timeCell.ID, resource.ID, description)
-						if ret == -1 {
-							return nil
+					if timeCell.Time == slot && timeCell.Booked == false {
+						violation := sched.checkRules(bookerID, date, resource.Name, timeCell.ID)
+						if violation != nil {
+							sched.log(LevelWarn, "booking breaks the rules", F("date", attempt.Date), F("time", slot), F("court", resource.Name), F("error", violation))
+							violations = append(violations, violation.Error())
+							continue
+						}
+						attempt.Court = resource.Name
+						_, err := sched.bookTimeSlot(bookerID, date, timeCell.ID, resource.ID, description, attempt)
+						if err == errBusy {
+							return nil, err
+						}
+						if err != nil {
+							return nil, nil
 						}
-						return &resource.Name
+						attempt.Booked = true
+						attempt.Failure = ""
+						return &resource.Name, nil
 					}
 				}
 			}
 		}
 	}
+	if len(violations) == 0 {
+		attempt.fail("not available")
+	}
+	for _, violation := range violations {
+		attempt.fail(violation)
+	}
+	return nil, nil
+}
+
+// CurrentUser returns the user of the session, nil if the users are not
+// read yet or the user is not among them.
+func (sched *Schedule) CurrentUser() *User {
+	for index := range sched.users {
+		if uint64(sched.users[index].ID) == sched.session.GetUserId() {
+			return &sched.users[index]
+		}
+	}
 	return nil
 }
 
-func (sched *Schedule) getter(resname string, mapobj interface{}) error {
-	_, resp, err := Get(sched.session, resname)
-	log.Printf("Required %s", resname)
+// SetBookFor makes the schedule book the slots for the user with the
+// username or the name, e.g. a teammate; my account needs the permission to
+// book for others. The duplicates and the rules are checked against the
+// bookings of that user. An empty name books for me.
+func (sched *Schedule) SetBookFor(name string) {
+	sched.bookFor = name
+}
+
+// Booker returns the user the schedule books for, an error if the user is
+// not found among the refreshed users or I may not book for them.
+func (sched *Schedule) Booker() (*User, error) {
+	me := sched.CurrentUser()
+	if sched.bookFor == "" {
+		if me == nil {
+			return nil, fmt.Errorf("user %d is not found", sched.session.GetUserId())
+		}
+		return me, nil
+	}
+	var found *User
+	for index := range sched.users {
+		user := &sched.users[index]
+		if strings.EqualFold(user.Username, sched.bookFor) {
+			found = user
+			break
+		}
+		if strings.EqualFold(user.Name, sched.bookFor) {
+			if found != nil {
+				return nil, fmt.Errorf("name %s is ambiguous, use the username", sched.bookFor)
+			}
+			found = user
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("user %s is not found", sched.bookFor)
+	}
+	if uint64(found.ID) != sched.session.GetUserId() && (me == nil || !(me.AllowAlterOthers || me.Administrator)) {
+		return nil, fmt.Errorf("%s may not book for others", sched.session.Username())
+	}
+	return found, nil
+}
+
+// bookerID is the ID of the user the schedule books for.
+// bookerName returns the username of the teammate the schedule books for,
+// empty for my own bookings.
+func (sched *Schedule) bookerName() string {
+	if sched.bookFor == "" {
+		return ""
+	}
+	booker, err := sched.Booker()
 	if err != nil {
-		log.Printf("Request %s failed: %s", resname, err.Error())
-		return err
+		return sched.bookFor
+	}
+	return booker.Username
+}
+
+func (sched *Schedule) bookerID() (uint64, error) {
+	if sched.bookFor == "" {
+		return sched.session.GetUserId(), nil
 	}
-	body, err := ioutil.ReadAll(resp.Body)
+	booker, err := sched.Booker()
 	if err != nil {
-		log.Printf("Failed to read body of %s request: %s", resname, err.Error())
-		return err
+		return 0, err
+	}
+	return uint64(booker.ID), nil
+}
+
+// userName returns the name of the user, the username if the name is
+// hidden or the member keeps the details private, or the ID if the user is
+// unknown. My own name is always shown.
+func (sched *Schedule) userName(userID int) string {
+	for _, user := range sched.users {
+		if user.ID != userID {
+			continue
+		}
+		if user.MemberDetailsPrivate && uint64(user.ID) != sched.session.GetUserId() {
+			return user.Username
+		}
+		return firstOf(user.Name, user.Username)
+	}
+	return fmt.Sprintf("user %d", userID)
+}
+
+func (sched *Schedule) MyBookings() []BookingDetails {
+	return sched.bookingDetails(sched.session.GetUserId())
+}
+
+// Bookings returns the bookings of all the users for the week.
+func (sched *Schedule) Bookings() []BookingDetails {
+	return sched.bookingDetails(0)
+}
+
+// bookingDetails describes the bookings of the user, all of them for 0.
+func (sched *Schedule) bookingDetails(userID uint64) []BookingDetails {
+	courts := make(map[int]string)
+	for _, resource := range sched.resources {
+		courts[resource.ID] = resource.Description
 	}
-	err = json.Unmarshal(body, mapobj)
+	timeSlots := make(map[int]TimeSlot)
+	for _, timeSlot := range sched.timeSlots {
+		timeSlots[timeSlot.ID] = timeSlot
+	}
+	dates := make(map[int]string)
+	for _, bookedTimeSlot := range sched.booked_time_slots {
+		dates[bookedTimeSlot.ID] = bookedTimeSlot.BookingDate
+	}
+
+	found := make([]BookingDetails, 0)
+	for _, booking := range sched.bookings {
+		if userID != 0 && uint64(booking.BookedByUserID) != userID {
+			continue
+		}
+		details := BookingDetails{
+			ID:          booking.ID,
+			Court:       courts[booking.ResourceID],
+			Date:        dates[booking.BookedTimeSlotID],
+			Booker:      sched.userName(booking.BookedByUserID),
+			Description: booking.Description,
+		}
+		timeSlot, ok := timeSlots[sched.bts2ts[booking.BookedTimeSlotID]]
+		if ok {
+			details.Day = dayNames[timeSlot.DayOfWeek-1]
+			details.Time = timeSlot.Description
+		}
+		found = append(found, details)
+	}
+	return found
+}
+
+// CancelBooking deletes the booking, in the dry run the request is only
+// logged.
+func (sched *Schedule) CancelBooking(bookingID int) error {
+	endpoint := fmt.Sprintf("bookings/%d", bookingID)
+	if sched.dryRun {
+		sched.planned = append(sched.planned, PlannedRequest{Method: "DELETE", Endpoint: endpoint})
+		sched.log(LevelInfo, "dry run, request is not sent", F("method", "DELETE"), F("endpoint", endpoint))
+		return nil
+	}
+	code, _, err := sched.api.Request("DELETE", endpoint, nil)
 	if err != nil {
-		log.Printf("Failed on %s JSON unmarshaling: %s", resname, err.Error())
 		return err
 	}
+	if code != 200 && code != 204 {
+		return fmt.Errorf("can not cancel booking %d: status %d", bookingID, code)
+	}
 	return nil
 }
 
-func (sched *Schedule) poster(resname string, request_payload *[]byte, respobj interface{}) error {
-	_, resp, err := Post(sched.session, resname, request_payload)
+// BookPreferredIfPossible tries the courts in the given order and books the
+// first free one. An empty list means any court is acceptable, as does an
+// empty court in the list once the courts before it are taken. The attempt
+// is recorded once with all the courts tried. If the slot is mine already
+// its court is returned and AlreadyHeld tells so.
+func (sched *Schedule) BookPreferredIfPossible(courts []string, day string, slot string, description string) *string {
+	booked, attempt, result := sched.tryPreferred(courts, day, slot, description)
+	sched.held = result == held
+	if result == tried {
+		sched.record(attempt)
+	}
+	return booked
+}
+
+// AlreadyHeld tells whether the court returned by the last booking call is
+// my booking made before, not a new one.
+func (sched *Schedule) AlreadyHeld() bool {
+	return sched.held
+}
+
+// tryResult tells what became of a try to book the slot.
+type tryResult int
+
+const (
+	// tried is an attempt to record, booked or not
+	tried tryResult = iota
+	// held means the slot is mine already, nothing is booked or recorded
+	held
+	// busy means another process books the slot, nothing is recorded
+	busy
+)
+
+// tryPreferred is BookPreferredIfPossible leaving the attempt to the caller
+// to record, so the loops retrying the booking record only their outcome.
+// The court of a failed attempt is the first court tried, the one lost.
+func (sched *Schedule) tryPreferred(courts []string, day string, slot string, description string) (*string, Attempt, tryResult) {
+	attempt := sched.newAttempt(day, slot)
+	existing, err := sched.duplicateOf(attempt.Date, slot, courts)
+	if existing != nil {
+		return &existing.Court, attempt, held
+	}
 	if err != nil {
-		return err
+		attempt.fail(err.Error())
+		return nil, attempt, busy
+	}
+	if len(courts) == 0 {
+		courts = []string{""}
+	}
+	for _, court := range courts {
+		booked, err := sched.bookCourt(court, day, slot, description, &attempt)
+		if booked != nil {
+			return booked, attempt, tried
+		}
+		if err == errBusy {
+			// the other process may have booked it since the schedule was read
+			err = sched.Refresh()
+			if err == nil {
+				existing, _ = sched.duplicateOf(attempt.Date, slot, courts)
+			}
+			if existing != nil {
+				return &existing.Court, attempt, held
+			}
+			return nil, attempt, busy
+		}
 	}
-	body, err := ioutil.ReadAll(resp.Body)
+	attempt.Court = courts[0]
+	return nil, attempt, tried
+}
+
+// BookOnDate switches the schedule to the week of the date and books the
+// slot of that exact date, see BookPreferredIfPossible.
+func (sched *Schedule) BookOnDate(date time.Time, courts []string, slot string, description string) *string {
+	booked, attempt, result := sched.tryOnDate(date, courts, slot, description)
+	sched.held = result == held
+	if result == tried {
+		sched.record(attempt)
+	}
+	return booked
+}
+
+// tryOnDate is BookOnDate leaving the attempt to the caller to record, see
+// tryPreferred.
+func (sched *Schedule) tryOnDate(date time.Time, courts []string, slot string, description string) (*string, Attempt, tryResult) {
+	sched.session.SetFaketime(date.Format("2006-01-02"))
+	day := dayNames[date.Weekday()]
+	err := sched.Refresh()
+	if err != nil {
+		attempt := sched.newAttempt(day, slot)
+		attempt.fail(err.Error())
+		return nil, attempt, tried
+	}
+	return sched.tryPreferred(courts, day, slot, description)
+}
+
+// SlotStatus tells the state of the slot on the date for the preferred
+// courts: "mine", "free", "booked", "missing" if there is no such slot or
+// "unknown" if the schedule can't be read.
+func (sched *Schedule) SlotStatus(date time.Time, courts []string, slot string) string {
+	sched.session.SetFaketime(date.Format("2006-01-02"))
+	err := sched.Refresh()
+	if err != nil {
+		return "unknown"
+	}
+	day := dayNames[date.Weekday()]
+	status := "missing"
+	for _, resource := range sched.RenderSchedule() {
+		if !preferred(courts, resource.Name) {
+			continue
+		}
+		for _, cell := range resource.Days[date.Weekday()].Cells {
+			if cell.Time != slot {
+				continue
+			}
+			if cell.Mine {
+				return "mine"
+			}
+			if !cell.Booked {
+				status = "free"
+			} else if status == "missing" {
+				status = "booked"
+			}
+		}
+	}
+	sched.log(LevelDebug, "slot status", F("date", date.Format("2006-01-02")), F("day", day), F("time", slot), F("status", status))
+	return status
+}
+
+func preferred(courts []string, court string) bool {
+	for _, name := range courts {
+		if name == "" || name == court {
+			return true
+		}
+	}
+	return len(courts) == 0
+}
+
+func (sched *Schedule) GetGroup() (*Group, error) {
+	var group Group
+	err := sched.getter(fmt.Sprintf("groups/%d", sched.session.GetGroupId()), &group)
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// readGroup reads the group once per schedule, nil if it can't be read.
+func (sched *Schedule) readGroup() *Group {
+	if !sched.groupRead {
+		group, err := sched.GetGroup()
+		if err != nil {
+			sched.log(LevelWarn, "can not get the group, the weeks start on Monday in the local timezone", F("error", err))
+		}
+		sched.group, sched.groupRead = group, true
+	}
+	return sched.group
+}
+
+// Location returns the timezone of the group, or the local one if the group
+// can't be read.
+func (sched *Schedule) Location() (*time.Location, error) {
+	if sched.location != nil {
+		return sched.location, nil
+	}
+	group := sched.readGroup()
+	location := time.Local
+	if group != nil && group.Timezone != "" {
+		var err error
+		location, err = time.LoadLocation(group.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("unknown timezone of the group %s: %s", group.Timezone, err.Error())
+		}
+	}
+	sched.location = location
+	return location, nil
+}
+
+func (sched *Schedule) getter(resname string, mapobj interface{}) error {
+	return sched.call("GET", resname, nil, mapobj)
+}
+
+func (sched *Schedule) poster(resname string, request_payload *[]byte, respobj interface{}) error {
+	var payload []byte
+	if request_payload != nil {
+		payload = *request_payload
+	}
+	return sched.call("POST", resname, payload, respobj)
+}
+
+// statusError is the rejection of the request by the API, unlike the network
+// errors it means the request had no effect.
+type statusError int
+
+func (code statusError) Error() string {
+	return fmt.Sprintf("status %d", int(code))
+}
+
+// call makes the request through the API of the schedule and decodes the
+// JSON response into respobj.
+func (sched *Schedule) call(method string, resname string, payload []byte, respobj interface{}) error {
+	code, body, err := sched.api.Request(method, resname, payload)
 	if err != nil {
-		log.Printf("Failed to read body of %s request: %s", resname, err.Error())
 		return err
 	}
+	if code >= 300 {
+		sched.log(LevelWarn, "request is rejected", F("method", method), F("endpoint", resname), F("status", code))
+		return statusError(code)
+	}
 	err = json.Unmarshal(body, respobj)
 	if err != nil {
-		log.Printf("Failed on %s JSON unmarshaling: %s", resname, err.Error())
+		sched.log(LevelWarn, "can not unmarshal the response", F("method", method), F("endpoint", resname), F("error", err))
 		return err
 	}
 	return nil
 }
 
-func (sched *Schedule) getUsers() []User {
+func (sched *Schedule) getUsers() ([]User, error) {
 	type UserResponse struct {
 		Users []User `json:"users"`
 	}
 	var users UserResponse
 	err := sched.getter("users", &users)
 	if err != nil {
-		log.Fatalf("Can not get users: %s", err.Error())
+		sched.log(LevelError, "can not get the users", F("error", err))
+		return nil, err
 	}
-	return users.Users
+	return users.Users, nil
 }
 
-func (sched *Schedule) getResources() []Resource {
+func (sched *Schedule) getResources() ([]Resource, error) {
 	type ResourecesReponse struct {
 		Resources []Resource `json:"resources"`
 	}
 	var resources ResourecesReponse
 	err := sched.getter("resources", &resources)
 	if err != nil {
-		log.Fatalf("Can't get resources: %s", err.Error())
+		sched.log(LevelError, "can not get the resources", F("error", err))
+		return nil, err
 	}
-	return resources.Resources
+	return resources.Resources, nil
 }
 
-func (sched *Schedule) getTimeSlots() []TimeSlot {
+func (sched *Schedule) getTimeSlots() ([]TimeSlot, error) {
 	type TimeSlotReponse struct {
 		TimeSlots []TimeSlot `json:"time_slots"`
 	}
 	var timeSlots TimeSlotReponse
 	err := sched.getter("time_slots", &timeSlots)
 	if err != nil {
-		log.Fatalf("Can't get time slots: %s", err.Error())
+		sched.log(LevelError, "can not get the time slots", F("error", err))
+		return nil, err
 	}
-	return timeSlots.TimeSlots
+	return timeSlots.TimeSlots, nil
 }
 
-func (sched *Schedule) getBookings() []Boooking {
+func (sched *Schedule) getBookings() ([]Boooking, error) {
 	type BookingsResponse struct {
 		Bookings []Boooking `json:"bookings"`
 	}
@@ -290,9 +883,33 @@ func (sched *Schedule) getBookings() []Boooking {
 	uri := fmt.Sprintf("bookings/week/%d/%02d/%02d", date.Year(), date.Month(), date.Day())
 	err := sched.getter(uri, &bookings)
 	if err != nil {
-		log.Fatalf("Can't get bookings: %s", err.Error())
+		sched.log(LevelError, "can not get the bookings", F("error", err))
+		return nil, err
 	}
-	return bookings.Bookings
+	return bookings.Bookings, nil
+}
+
+// ForWeeks refreshes the schedule for each of the given number of weeks
+// starting from the current one and calls visit for it. The week of the
+// session is restored after.
+func (sched *Schedule) ForWeeks(weeks int, visit func() error) error {
+	if weeks <= 0 {
+		weeks = 1
+	}
+	start := sched.getDate()
+	defer sched.session.SetFaketime(sched.session.GetFakeTime())
+	for week := 0; week < weeks; week++ {
+		sched.session.SetFaketime(start.AddDate(0, 0, 7*week).Format("2006-01-02"))
+		err := sched.Refresh()
+		if err != nil {
+			return err
+		}
+		err = visit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (sched *Schedule) getDate() time.Time {
@@ -304,13 +921,48 @@ func (sched *Schedule) getDate() time.Time {
 		var err error
 		tprocess, err = time.Parse("2006-01-02", faketime)
 		if err != nil {
-			log.Fatalf("Error with parsing fake time %s. Should have a format YYYY-MM-DD", faketime)
+			sched.log(LevelError, "can not parse the fake time, should have a format YYYY-MM-DD", F("faketime", faketime))
+			os.Exit(1)
 		}
 	}
 	return tprocess
 }
 
-func (sched *Schedule) getBookedTimeSlots() []BookedTimeSlot {
+// WeekStart returns the first day of the week of the date. The first day of
+// the week is numbered like Group.FirstDayOfWeek, 1 is Sunday and 7 is
+// Saturday; the weeks of an unknown one start on Monday.
+func WeekStart(date time.Time, firstDayOfWeek int) time.Time {
+	if firstDayOfWeek < 1 || firstDayOfWeek > 7 {
+		firstDayOfWeek = 2
+	}
+	first := time.Weekday(firstDayOfWeek - 1)
+	return date.AddDate(0, 0, -((int(date.Weekday()) - int(first) + 7) % 7))
+}
+
+// weekStart returns the first day of the week of getDate. The first day of
+// the week of the group is read once, Monday is used if it can't be read.
+func (sched *Schedule) weekStart() time.Time {
+	first := 0
+	if group := sched.readGroup(); group != nil {
+		first = group.FirstDayOfWeek
+	}
+	return WeekStart(sched.getDate(), first)
+}
+
+// dateOf returns the date of the day within the week of getDate, the weeks
+// start on the first day of the week of the group like the ones the booking
+// API returns.
+func (sched *Schedule) dateOf(day string) time.Time {
+	date := sched.getDate()
+	weekday, err := weekdayOf(day)
+	if err != nil {
+		return date
+	}
+	start := sched.weekStart()
+	return start.AddDate(0, 0, (int(weekday)-int(start.Weekday())+7)%7)
+}
+
+func (sched *Schedule) getBookedTimeSlots() ([]BookedTimeSlot, error) {
 	type BookedTimeSlotResponse struct {
 		BookedTimeSlots []BookedTimeSlot `json:"booked_time_slots"`
 	}
@@ -319,9 +971,10 @@ func (sched *Schedule) getBookedTimeSlots() []BookedTimeSlot {
 	uri := fmt.Sprintf("booked_time_slots/week/%d/%02d/%02d", date.Year(), date.Month(), date.Day())
 	err := sched.getter(uri, &timeSlots)
 	if err != nil {
-		log.Fatalf("Can't get booked time slots: %s", err.Error())
+		sched.log(LevelError, "can not get the booked time slots", F("error", err))
+		return nil, err
 	}
-	return timeSlots.BookedTimeSlots
+	return timeSlots.BookedTimeSlots, nil
 }
 
 func (sched *Schedule) GetResources() []Resource {
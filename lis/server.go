@@ -0,0 +1,234 @@
+package lis
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type APIBookingRequest struct {
+	Date        string   `json:"date"`
+	Day         string   `json:"day"`
+	Time        string   `json:"time"`
+	Courts      []string `json:"courts"`
+	Description string   `json:"description"`
+}
+
+// Server exposes the schedule of a single authorised session over HTTP, the
+// REST API and the web UI. The requests take turns on the lock, see
+// refreshWeek.
+type Server struct {
+	session     *Client
+	sched       *Schedule
+	token       string
+	lock        sync.Mutex
+	Courts      []string
+	Description string
+}
+
+func NewServer(session *Client, token string) (*Server, error) {
+	if token == "" {
+		return nil, fmt.Errorf("API token is required")
+	}
+	sched, err := NewSchedule(session)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		session:     session,
+		sched:       sched,
+		token:       token,
+		Description: "To Play",
+	}, nil
+}
+
+// Schedule gives access to the schedule of the server, e.g. to set the
+// history or the rules before ListenAndServe.
+func (server *Server) Schedule() *Schedule {
+	return server.sched
+}
+
+func writeJSON(w http.ResponseWriter, status int, document interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(document)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, ErrorResult{Error: fmt.Sprintf(format, args...)})
+}
+
+func (server *Server) authorised(r *http.Request) bool {
+	token := r.URL.Query().Get("token")
+	header := r.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		token = strings.TrimPrefix(header, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(server.token)) == 1
+}
+
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.session.log(LevelInfo, "api request", F("method", r.Method), F("path", r.URL.Path))
+	if r.URL.Path == "/" || r.URL.Path == "/index.html" {
+		if r.Method != "GET" {
+			writeError(w, http.StatusMethodNotAllowed, "method %s is not allowed", r.Method)
+			return
+		}
+		serveUI(w, r)
+		return
+	}
+	if !server.authorised(r) {
+		writeError(w, http.StatusUnauthorized, "wrong API token")
+		return
+	}
+	switch {
+	case r.URL.Path == "/schedule":
+		server.onlyMethod(w, r, "GET", server.handleSchedule)
+	case r.URL.Path == "/bookings/mine":
+		server.onlyMethod(w, r, "GET", server.handleMine)
+	case r.URL.Path == "/bookings":
+		server.onlyMethod(w, r, "POST", server.handleBook)
+	case strings.HasPrefix(r.URL.Path, "/bookings/"):
+		server.onlyMethod(w, r, "DELETE", server.handleCancel)
+	default:
+		writeError(w, http.StatusNotFound, "unknown path %s", r.URL.Path)
+	}
+}
+
+func (server *Server) onlyMethod(w http.ResponseWriter, r *http.Request, method string, handler http.HandlerFunc) {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, "method %s is not allowed", r.Method)
+		return
+	}
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	handler(w, r)
+}
+
+// refresh re-authorises if the session has expired and reads the week of
+// the date, the current week if the date is empty.
+func (server *Server) refresh(date string) error {
+	return refreshWeek(server.session, server.sched, date)
+}
+
+// refreshWeek re-authorises if the session has expired and reads the week of
+// the date into the schedule, the current week if the date is empty. The
+// date is checked before it becomes the fake time of the session. The
+// schedule keeps that week until the next refresh, so the server and the bot
+// handle one request at a time from the refresh to the reply.
+func refreshWeek(session *Client, sched *Schedule, date string) error {
+	if date != "" {
+		_, err := parseDate("date", date)
+		if err != nil {
+			return err
+		}
+	}
+	session.SetFaketime(date)
+	err := session.Authorise()
+	if err != nil {
+		return err
+	}
+	return sched.Refresh()
+}
+
+func (server *Server) handleSchedule(w http.ResponseWriter, r *http.Request) {
+	err := server.refresh(r.URL.Query().Get("date"))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "can not get the schedule: %s", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, ScheduleResult{TimeTables: server.sched.RenderSchedule()})
+}
+
+func (server *Server) handleMine(w http.ResponseWriter, r *http.Request) {
+	err := server.refresh(r.URL.Query().Get("date"))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "can not get the schedule: %s", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, BookingsResult{Bookings: server.sched.MyBookings()})
+}
+
+func (server *Server) handleBook(w http.ResponseWriter, r *http.Request) {
+	var request APIBookingRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "wrong booking request: %s", err.Error())
+		return
+	}
+	if request.Date != "" {
+		date, err := parseDate("date", request.Date)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "%s", err.Error())
+			return
+		}
+		request.Day = dayNames[date.Weekday()]
+	}
+	if request.Day == "" || request.Time == "" {
+		writeError(w, http.StatusBadRequest, "day or date and time are required")
+		return
+	}
+	if len(request.Courts) == 0 {
+		request.Courts = server.Courts
+	}
+	if request.Description == "" {
+		request.Description = server.Description
+	}
+
+	err = server.refresh(request.Date)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "can not get the schedule: %s", err.Error())
+		return
+	}
+	booked := server.sched.BookPreferredIfPossible(request.Courts, request.Day, request.Time, request.Description)
+	result := BookingResult{
+		Booked:      booked != nil && !server.sched.AlreadyHeld(),
+		AlreadyHeld: booked != nil && server.sched.AlreadyHeld(),
+		Date:        server.sched.dateOf(request.Day).Format("2006-01-02"),
+		Day:         request.Day,
+		Time:        request.Time,
+		Description: request.Description,
+	}
+	if booked == nil {
+		writeJSON(w, http.StatusConflict, result)
+		return
+	}
+	result.Court = *booked
+	if result.AlreadyHeld {
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+	writeJSON(w, http.StatusCreated, result)
+}
+
+func (server *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	bookingID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/bookings/"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "wrong booking id")
+		return
+	}
+	err = server.session.Authorise()
+	if err == nil {
+		err = server.sched.CancelBooking(bookingID)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "can not cancel the booking: %s", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, CancelResult{ID: bookingID, Cancelled: true})
+}
+
+func (server *Server) ListenAndServe(address string) error {
+	httpServer := &http.Server{
+		Addr:              address,
+		Handler:           server,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return httpServer.ListenAndServe()
+}
@@ -0,0 +1,136 @@
+package lis
+
+import (
+	"LIS/lis"
+	"LIS/lis/fake"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBookFor(t *testing.T) {
+	server, sched := fakeClub(t, "DEMO")
+	sched.Refresh()
+
+	sched.SetBookFor("test player")
+	booker, err := sched.Booker()
+	if err != nil || booker.ID != 123 {
+		t.Fatalf("Teammate is not found by the name: %v", err)
+	}
+	booked := sched.BookIfPossible("Tue", "2pm - 7pm", "Team game")
+	if booked == nil {
+		t.Fatal("Slot is not booked for the teammate")
+	}
+	bookings := server.Bookings()
+	if len(bookings) != 1 || bookings[0].BookedByUserID != 123 {
+		t.Errorf("Booking is not made for the teammate: %+v", bookings)
+	}
+
+	// the duplicates and the rules are checked for the teammate
+	sched.Refresh()
+	booked = sched.BookCourtIfPossible(*booked, "Tue", "2pm - 7pm", "Team game")
+	if booked == nil || len(server.Bookings()) != 1 {
+		t.Errorf("Booking of the teammate is not found")
+	}
+	sched.SetRules(&lis.Rules{MaxPrime: 1})
+	if sched.BookIfPossible("Wed", "2pm - 7pm", "Team game") != nil {
+		t.Errorf("Prime slots of the teammate are not counted")
+	}
+	sched.SetBookFor("")
+	if sched.BookIfPossible("Wed", "2pm - 7pm", "My game") == nil {
+		t.Errorf("My prime slot is not booked")
+	}
+
+	sched.SetBookFor("nobody")
+	if _, err = sched.Booker(); err == nil {
+		t.Errorf("Unknown user is accepted")
+	}
+	if sched.BookIfPossible("Thu", "9am - 2pm", "Team game") != nil {
+		t.Errorf("Slot is booked for the unknown user")
+	}
+}
+
+func TestBookForWithoutPermission(t *testing.T) {
+	server, sched := fakeClub(t, "TEST")
+	sched.Refresh()
+	sched.SetBookFor("DEMO")
+	if _, err := sched.Booker(); err == nil {
+		t.Errorf("Booking for others is allowed without the permission")
+	}
+	if sched.BookIfPossible("Tue", "2pm - 7pm", "To Play") != nil {
+		t.Errorf("Slot is booked for others without the permission")
+	}
+	for _, request := range server.Requests() {
+		if request == "POST /bookings" {
+			t.Errorf("Booking is sent without the permission")
+		}
+	}
+	sched.SetBookFor("test")
+	booker, err := sched.Booker()
+	if err != nil || booker.ID != 123 {
+		t.Errorf("Booking for myself is refused: %v", err)
+	}
+}
+
+func TestDaemonChecksBookers(t *testing.T) {
+	testsrvr := httptest.NewServer(fake.New())
+	defer testsrvr.Close()
+	instance := lis.NewInstance(testsrvr.URL, "TEST", "TEST", "TEST")
+	instance.Authorise()
+	jobs := []lis.Job{
+		{Name: "mine", Day: "Tue", Time: "2pm - 7pm", Release: "00:00"},
+		{Name: "team", Day: "Wed", Time: "2pm - 7pm", Release: "00:00", For: "DEMO"},
+	}
+	_, err := lis.NewDaemon(instance, jobs, time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "job team") {
+		t.Errorf("Job booking for others without the permission is accepted: %v", err)
+	}
+
+	instance = lis.NewInstance(testsrvr.URL, "DEMO", "DEMO", "TEST")
+	instance.Authorise()
+	_, err = lis.NewDaemon(instance, jobs, time.Minute)
+	if err != nil {
+		t.Errorf("Job booking for a teammate is refused: %s", err.Error())
+	}
+	jobs[1].For = "nobody"
+	_, err = lis.NewDaemon(instance, jobs, time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "nobody") {
+		t.Errorf("Job booking for an unknown user is accepted: %v", err)
+	}
+}
+
+func TestEventsOfBookingFor(t *testing.T) {
+	testsrvr := httptest.NewServer(fake.New())
+	defer testsrvr.Close()
+	instance := lis.NewInstance(testsrvr.URL, "DEMO", "DEMO", "TEST")
+	instance.Authorise()
+	instance.SetFaketime("2022-11-29")
+	events := make(eventChannel, 4)
+	instance.SetNotifier(events)
+	sched, err := lis.NewSchedule(instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sched.SetBookFor("test player")
+	if sched.Snipe(time.Now(), nil, "Tue", "2pm - 7pm", "Team game", time.Millisecond, 1) == nil {
+		t.Fatal("Slot is not booked for the teammate")
+	}
+	if event := waitEvent(t, events); event.Type != lis.EventBookingSucceeded || event.For != "TEST" {
+		t.Errorf("Event doesn't tell the teammate: %+v", event)
+	}
+
+	job := lis.Job{Name: "team", Day: "Wed", Time: "2pm - 7pm", For: "TEST", Attempts: 1}
+	job.Prepare()
+	daemon, err := lis.NewDaemon(instance, []lis.Job{job}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outcome := daemon.RunJob(job, time.Now(), time.Date(2022, 11, 30, 0, 0, 0, 0, time.UTC))
+	if !outcome.Booked || outcome.For != "TEST" {
+		t.Errorf("Outcome doesn't tell the teammate: %+v", outcome)
+	}
+	if event := waitEvent(t, events); event.For != "TEST" {
+		t.Errorf("Event of the job doesn't tell the teammate: %+v", event)
+	}
+}
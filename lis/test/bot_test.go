@@ -0,0 +1,98 @@
+package lis
+
+import (
+	"LIS/lis"
+	"LIS/lis/fake"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBot(t *testing.T) {
+	testsrvr := httptest.NewServer(
+		http.HandlerFunc(mainHandler),
+	)
+	defer testsrvr.Close()
+	instance := lis.NewInstance(testsrvr.URL, "TEST", "TEST", "TEST")
+	err := instance.Authorise()
+	if err != nil {
+		t.Fatal("Auth credentials is not valid for the end user")
+	}
+	bot, err := lis.NewBot(instance)
+	if err != nil {
+		t.Fatalf("Can not create the bot: %s", err.Error())
+	}
+
+	cases := []struct {
+		text  string
+		reply string
+	}{
+		{"show 2022-11-29", "Tue 2022-11-29\n  9am - 2pm: "},
+		{"book 2022-11-29 14:00", "Booked Tue 2022-11-29 2pm - 7pm on "},
+		{"BOOK 2022-11-29 9am piper archer", "Booked Tue 2022-11-29 9am - 2pm on Piper Archer"},
+		{"book 2022-11-29 9am - 2pm", "Booked Tue 2022-11-29 9am - 2pm on "},
+		{"book 2022-11-29 9am hangar", "Error: unknown court \"hangar\""},
+		{"book 2022-11-29 3am", "Error: no slot \"3am\" on Tue"},
+		{"book someday 9am", "Error: unknown day \"someday\""},
+		{"cancel 11764275", "Cancelled 11764275"},
+		{"cancel latest", "Error: wrong booking id \"latest\""},
+		{"dance", "Unknown command \"dance\""},
+	}
+	for _, c := range cases {
+		reply := bot.Handle(lis.Message{From: "alice", Text: c.text})
+		if !strings.HasPrefix(reply, c.reply) {
+			t.Errorf("%q is answered with %q", c.text, reply)
+		}
+	}
+
+	reply := bot.Handle(lis.Message{Text: "show 2022-11-29"})
+	if strings.Count(reply, "\n") != 2 || !strings.Contains(reply, "Cessna 172") || !strings.Contains(reply, "Piper Archer") {
+		t.Errorf("Free slots are shown wrong:\n%s", reply)
+	}
+
+	var out bytes.Buffer
+	adapter := lis.StdinAdapter{In: strings.NewReader("help\n\nquit\ndance\n"), Out: &out}
+	err = adapter.Serve(bot.Handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "Commands:") || strings.Contains(out.String(), "Unknown command") {
+		t.Errorf("Stdin adapter answered wrong:\n%s", out.String())
+	}
+}
+
+func TestBotTodayOfGroup(t *testing.T) {
+	// one of the zones is on another date than the local one at any time
+	for _, zone := range []string{"Pacific/Kiritimati", "Etc/GMT+11"} {
+		location, err := time.LoadLocation(zone)
+		if err != nil {
+			t.Skipf("Timezone %s is not available: %s", zone, err.Error())
+		}
+		server := fake.New()
+		group := server.Group()
+		group.Timezone = zone
+		server.SetGroup(group)
+		testsrvr := httptest.NewServer(server)
+		defer testsrvr.Close()
+		instance := lis.NewInstance(testsrvr.URL, "TEST", "TEST", "TEST")
+		instance.Authorise()
+		bot, err := lis.NewBot(instance)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for text, date := range map[string]time.Time{
+			"show today":    time.Now().In(location),
+			"show tomorrow": time.Now().In(location).AddDate(0, 0, 1),
+		} {
+			expected := date.Format("Mon 2006-01-02") + "\n"
+			reply := bot.Handle(lis.Message{Text: text})
+			if !strings.HasPrefix(reply, expected) {
+				t.Errorf("%q in %s is answered with %q, expected %q", text, zone, reply, expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package lis
+
+import (
+	"LIS/lis"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCassette(t *testing.T) {
+	testsrvr := httptest.NewServer(
+		http.HandlerFunc(mainHandler),
+	)
+	defer testsrvr.Close()
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	recorder := lis.NewRecorder(path, nil)
+	instance := lis.NewInstance(testsrvr.URL, "TEST", "TEST", "TEST")
+	instance.SetTransport(recorder)
+	if instance.Authorise() != nil {
+		t.Fatal("Auth credentials is not valid for the end user")
+	}
+	sched, err := lis.NewSchedule(instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance.SetFaketime("2022-11-29")
+	if sched.Refresh() != nil {
+		t.Fatal("Schedule is not refreshed")
+	}
+	if sched.BookCourtIfPossible("Cessna 172", "Mon", "2pm - 7pm", "To Play") == nil {
+		t.Fatal("Failed to book the room")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `\"password\":\"[REDACTED]\"`) || strings.Contains(string(data), ".session1") {
+		t.Errorf("Credentials are recorded:\n%s", data)
+	}
+	cassette, err := lis.LoadCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cassette.Interactions) < 8 || cassette.Interactions[0].Request.URL != testsrvr.URL+"/sessions" {
+		t.Errorf("Traffic is recorded wrong: %+v", cassette.Interactions)
+	}
+
+	// the replay needs no server, the schedule is the recorded one
+	testsrvr.Close()
+	replayer, err := lis.NewReplayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := lis.NewInstance("http://replay.invalid", "TEST", "", "TEST")
+	replayed.SetTransport(replayer)
+	if replayed.Authorise() != nil {
+		t.Fatal("Replayed session is not authorised")
+	}
+	sched, err = lis.NewSchedule(replayed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed.SetFaketime("2022-11-29")
+	if sched.Refresh() != nil {
+		t.Fatal("Replayed schedule is not refreshed")
+	}
+	booked := sched.BookCourtIfPossible("Cessna 172", "Mon", "2pm - 7pm", "To Play")
+	if booked == nil || *booked != "Cessna 172" {
+		t.Errorf("Replayed booking failed")
+	}
+	_, _, err = lis.Get(replayed, "groups/999")
+	if err == nil || !strings.Contains(err.Error(), "no recorded interaction for GET /groups/999") {
+		t.Errorf("Unrecorded request is answered: %v", err)
+	}
+}
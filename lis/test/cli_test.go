@@ -0,0 +1,248 @@
+package lis
+
+import (
+	"LIS/lis"
+	"LIS/lis/fake"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestMain runs the command line instead of the tests when LIS_TEST_ARGS
+// is set, so the tests can run it in a child process like a user would and
+// check its output and exit code.
+func TestMain(m *testing.M) {
+	encoded := os.Getenv("LIS_TEST_ARGS")
+	if encoded != "" {
+		args := make([]string, 0)
+		json.Unmarshal([]byte(encoded), &args)
+		os.Args = append([]string{"lis"}, args...)
+		lis.Run()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+type cliResult struct {
+	stdout string
+	stderr string
+	code   int
+}
+
+// runCLI runs the command line with the arguments in a child process. The
+// home of the child is the directory, so its config, history and ledger are
+// kept there.
+func runCLI(t *testing.T, home string, args ...string) cliResult {
+	encoded, _ := json.Marshal(args)
+	cmd := exec.Command(os.Args[0])
+	for _, variable := range os.Environ() {
+		if !strings.HasPrefix(variable, "LIS_") && !strings.HasPrefix(variable, "XDG_") && !strings.HasPrefix(variable, "HOME=") {
+			cmd.Env = append(cmd.Env, variable)
+		}
+	}
+	cmd.Env = append(cmd.Env,
+		"LIS_TEST_ARGS="+string(encoded),
+		"HOME="+home,
+		"XDG_CONFIG_HOME="+filepath.Join(home, ".config"),
+		"XDG_DATA_HOME="+filepath.Join(home, ".local", "share"),
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	result := cliResult{stdout: stdout.String(), stderr: stderr.String()}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		result.code = exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatalf("Command line is not run: %s", err.Error())
+	}
+	return result
+}
+
+// fakeCLI starts the fake club and returns the arguments connecting the
+// command line to it as the user.
+func fakeCLI(t *testing.T, username string) (*fake.Server, []string) {
+	server := fake.New()
+	testsrvr := httptest.NewServer(server)
+	t.Cleanup(testsrvr.Close)
+	return server, []string{"-e", testsrvr.URL, "-u", username, "-p", username, "-g", "TEST"}
+}
+
+// command puts the command first, the arguments connecting to the club and
+// the rest after it.
+func command(name string, connect []string, args ...string) []string {
+	return append(append([]string{name}, connect...), args...)
+}
+
+func TestOutputDocuments(t *testing.T) {
+	_, connect := fakeCLI(t, "TEST")
+	home := t.TempDir()
+
+	result := runCLI(t, home, command("login", connect, "-o", "json")...)
+	login := lis.LoginResult{}
+	err := json.Unmarshal([]byte(result.stdout), &login)
+	if err != nil || result.code != 0 || login.Username != "TEST" || login.UserID != 123 || login.GroupID != 1234 {
+		t.Errorf("Wrong login document (%d): %s %v", result.code, result.stdout, err)
+	}
+
+	result = runCLI(t, home, command("book", connect, "--output", "yaml", "-d", "Tue", "-t", "2pm - 7pm", "-c", "Piper Archer")...)
+	booking := lis.BookingResult{}
+	err = yaml.Unmarshal([]byte(result.stdout), &booking)
+	if err != nil || result.code != 0 || !booking.Booked || booking.Court != "Piper Archer" || booking.Time != "2pm - 7pm" {
+		t.Errorf("Wrong booking document (%d): %s %v", result.code, result.stdout, err)
+	}
+
+	result = runCLI(t, home, command("mine", connect, "-o", "json")...)
+	bookings := lis.BookingsResult{}
+	err = json.Unmarshal([]byte(result.stdout), &bookings)
+	if err != nil || len(bookings.Bookings) != 1 || bookings.Bookings[0].Court != "Piper Archer" {
+		t.Errorf("Wrong bookings document: %s %v", result.stdout, err)
+	}
+
+	result = runCLI(t, home, command("book", connect, "-o", "json", "-d", "Tue", "-t", "2pm - 7pm", "-c", "Cessna 172", "--for", "nobody")...)
+	failure := lis.ErrorResult{}
+	err = json.Unmarshal([]byte(result.stdout), &failure)
+	if err != nil || result.code != 1 || !strings.Contains(failure.Error, "nobody") {
+		t.Errorf("Wrong error document (%d): %s %v", result.code, result.stdout, err)
+	}
+}
+
+func TestOutputParsingErrors(t *testing.T) {
+	home := t.TempDir()
+	for _, args := range [][]string{
+		{"book", "-o", "json", "-d", "Tue"},
+		{"book", "--output=json", "--unknown"},
+	} {
+		result := runCLI(t, home, args...)
+		failure := lis.ErrorResult{}
+		err := json.Unmarshal([]byte(result.stdout), &failure)
+		if err != nil || result.code != 1 || !strings.HasPrefix(failure.Error, "Error on parsing") {
+			t.Errorf("Parsing error of %v is not a document (%d): %s", args, result.code, result.stdout)
+		}
+	}
+	result := runCLI(t, home, "snipe", "-o", "yaml", "-e", "http://club.invalid", "-u", "TEST", "-p", "TEST", "-g", "TEST", "-d", "Tue", "-t", "2pm - 7pm", "-a", "soon")
+	failure := lis.ErrorResult{}
+	err := yaml.Unmarshal([]byte(result.stdout), &failure)
+	if err != nil || result.code != 1 || !strings.Contains(failure.Error, "release time soon") {
+		t.Errorf("Wrong release time is not a document (%d): %s", result.code, result.stdout)
+	}
+
+	// the text usage is kept for the people
+	result = runCLI(t, home, "book", "-d", "Tue")
+	if result.code != 1 || !strings.Contains(result.stdout, "usage:") {
+		t.Errorf("Usage is not printed (%d): %s", result.code, result.stdout)
+	}
+}
+
+// writeProfile writes the config file of the home with the profile as the
+// default one.
+func writeProfile(t *testing.T, home string, profile string) {
+	dir := filepath.Join(home, ".config", "lis")
+	err := os.MkdirAll(dir, 0700)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "config.yaml"), []byte("default_profile: club\nprofiles:\n  club:\n"+profile), 0600)
+	}
+	if err != nil {
+		t.Fatalf("Config is not written: %s", err.Error())
+	}
+}
+
+func TestFallbackAnyCourt(t *testing.T) {
+	server, connect := fakeCLI(t, "TEST")
+	home := t.TempDir()
+	writeProfile(t, home, "    courts: [\"Piper Archer\"]\n")
+	now := time.Now()
+	tuesday := now.AddDate(0, 0, 1-(int(now.Weekday())+6)%7).Format("2006-01-02")
+	_, err := server.Book(360847, 77791, 759165, tuesday, "Taken")
+	if err != nil {
+		t.Fatalf("Slot is not taken: %s", err.Error())
+	}
+
+	result := runCLI(t, home, command("book", connect, "-o", "json", "-d", "Tue", "-t", "2pm - 7pm")...)
+	if result.code == 0 || len(server.Bookings()) != 1 {
+		t.Errorf("Other court is booked without the fallback: %s", result.stdout)
+	}
+	result = runCLI(t, home, command("book", connect, "-o", "json", "-d", "Tue", "-t", "2pm - 7pm", "--fallback-any-court")...)
+	booking := lis.BookingResult{}
+	json.Unmarshal([]byte(result.stdout), &booking)
+	if result.code != 0 || booking.Court != "Cessna 172" {
+		t.Errorf("Any court is not booked with the fallback (%d): %s", result.code, result.stdout)
+	}
+
+	writeProfile(t, home, "    courts: [\"Piper Archer\"]\n    fallback_any_court: true\n")
+	server.Book(360847, 77791, 759167, now.AddDate(0, 0, 2-(int(now.Weekday())+6)%7).Format("2006-01-02"), "Taken")
+	result = runCLI(t, home, command("book", connect, "-o", "json", "-d", "Wed", "-t", "2pm - 7pm")...)
+	booking = lis.BookingResult{}
+	json.Unmarshal([]byte(result.stdout), &booking)
+	if result.code != 0 || booking.Court != "Cessna 172" {
+		t.Errorf("Any court is not booked with the fallback of the profile (%d): %s", result.code, result.stdout)
+	}
+}
+
+func TestNotificationsBeforeExit(t *testing.T) {
+	var lock sync.Mutex
+	received := make([]string, 0)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		// a slow receiver, the event counts only if the sender still waits
+		select {
+		case <-time.After(300 * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+		lock.Lock()
+		received = append(received, r.Header.Get("X-LIS-Event"))
+		lock.Unlock()
+	}))
+	defer hook.Close()
+	_, connect := fakeCLI(t, "TEST")
+	home := t.TempDir()
+	writeProfile(t, home, "    webhooks:\n      - url: "+hook.URL+"\n")
+
+	result := runCLI(t, home, command("watch", connect, "-d", "Tue", "-t", "2pm - 7pm", "-c", "Piper Archer")...)
+	if result.code != 0 {
+		t.Fatalf("Slot is not booked (%d): %s %s", result.code, result.stdout, result.stderr)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(received) != 1 || received[0] != lis.EventBookingSucceeded {
+		t.Errorf("Notification is lost at the exit: %v", received)
+	}
+}
+
+func TestEmailsOnlyForEvents(t *testing.T) {
+	server, connect := fakeCLI(t, "TEST")
+	home := t.TempDir()
+	writeProfile(t, home, "    smtp:\n      host: 127.0.0.1\n      from: lis@club.example\n")
+
+	result := runCLI(t, home, command("login", connect)...)
+	if result.code != 0 {
+		t.Fatalf("Login failed (%d): %s", result.code, result.stdout)
+	}
+	for _, request := range server.Requests() {
+		if strings.HasPrefix(request, "GET /users") {
+			t.Errorf("Users are read for the emails of login: %s", request)
+		}
+	}
+
+	runCLI(t, home, command("watch", connect, "-d", "Tue", "-t", "2pm - 7pm", "-c", "Piper Archer")...)
+	reads := make(map[string]int)
+	for _, request := range server.Requests() {
+		reads[request]++
+	}
+	// the emails read the users only, the rest is read by the watch
+	if reads["GET /users"] != reads["GET /resources"]+1 {
+		t.Errorf("Emails are set up with more than the users: %v", reads)
+	}
+}
@@ -0,0 +1,50 @@
+package lis
+
+import (
+	"LIS/lis"
+	"LIS/lis/fake"
+	"net/http/httptest"
+	"testing"
+)
+
+// team is the kind of tooling built on the package: it keeps the clients of
+// its members in a field of the named type.
+type team struct {
+	members map[string]*lis.Client
+}
+
+func (t *team) join(client *lis.Client) error {
+	err := client.Authorise()
+	if err != nil {
+		return err
+	}
+	t.members[client.Username()] = client
+	return nil
+}
+
+func TestClient(t *testing.T) {
+	testsrvr := httptest.NewServer(fake.New())
+	defer testsrvr.Close()
+	squad := team{members: make(map[string]*lis.Client)}
+	for _, username := range []string{"TEST", "DEMO"} {
+		err := squad.join(lis.NewInstance(testsrvr.URL, username, username, "TEST"))
+		if err != nil {
+			t.Fatalf("%s is not authorised: %s", username, err.Error())
+		}
+	}
+	client := squad.members["DEMO"]
+	if client.GetUserId() != 360847 || client.GetGroupId() != 1234 || client.Groupname() != "TEST" || client.GetEndpoint() != testsrvr.URL {
+		t.Errorf("Accessors are wrong: %d %d %s %s", client.GetUserId(), client.GetGroupId(), client.Groupname(), client.GetEndpoint())
+	}
+	sched, err := lis.NewSchedule(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sched.Client() != client {
+		t.Errorf("Schedule has another client")
+	}
+	code, _, err := lis.Get(client, "users")
+	if err != nil || code != 200 {
+		t.Errorf("Get with the client failed: %d %v", code, err)
+	}
+}
@@ -0,0 +1,87 @@
+package lis
+
+import (
+	"LIS/lis"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+default_profile: club
+profiles:
+  club:
+    endpoint: https://club.example
+    group: SQUASH
+    username: player
+    password: secret
+    courts: ["Court 1", "Court 2"]
+    description: Weekly game
+  other:
+    endpoint: https://other.example
+    group: OTHER
+    username: other
+`
+
+func TestProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := ioutil.WriteFile(path, []byte(testConfig), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile, err := lis.LoadProfile(path, "")
+	if err != nil {
+		t.Fatalf("Failed to load the default profile: %s", err.Error())
+	}
+	if profile.Endpoint != "https://club.example" || len(profile.Courts) != 2 || profile.Description != "Weekly game" {
+		t.Errorf("Default profile is loaded wrong: %+v", profile)
+	}
+
+	t.Setenv("LIS_PASSWORD", "from-env")
+	t.Setenv("LIS_COURTS", "Court 3, Court 1")
+	profile, err = lis.LoadProfile(path, "other")
+	if err != nil {
+		t.Fatalf("Failed to load the named profile: %s", err.Error())
+	}
+	if profile.Username != "other" || profile.Password != "from-env" {
+		t.Errorf("Environment is not applied: %+v", profile)
+	}
+	if len(profile.Courts) != 2 || profile.Courts[0] != "Court 3" {
+		t.Errorf("LIS_COURTS is parsed wrong: %v", profile.Courts)
+	}
+
+	_, err = lis.LoadProfile(path, "missing")
+	if err == nil {
+		t.Errorf("Missing profile is not reported")
+	}
+
+	profile, err = lis.LoadProfile(filepath.Join(t.TempDir(), "absent.yaml"), "")
+	if err != nil || profile.Password != "from-env" {
+		t.Errorf("Absent config file should fall back to the environment: %v", err)
+	}
+}
+
+func TestConfigWithSecretsIsPrivate(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"password.yaml": testConfig,
+		"smtp.yaml":     "profiles:\n  club:\n    smtp:\n      host: smtp.example\n      password: secret\n",
+		"webhook.yaml":  "profiles:\n  club:\n    webhooks:\n      - url: https://hooks.example\n        secret: secret\n",
+	} {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, []byte(content), 0644)
+		_, err := lis.LoadConfigFile(path)
+		if err == nil || !strings.Contains(err.Error(), "too open") {
+			t.Errorf("Readable %s with plain secrets is accepted: %v", name, err)
+		}
+	}
+	// the secrets taken from elsewhere don't need it
+	path := filepath.Join(dir, "env.yaml")
+	ioutil.WriteFile(path, []byte("profiles:\n  club:\n    password_env: LIS_SECRET\n"), 0644)
+	_, err := lis.LoadConfigFile(path)
+	if err != nil {
+		t.Errorf("Config without plain secrets is refused: %s", err.Error())
+	}
+}
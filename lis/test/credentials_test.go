@@ -0,0 +1,61 @@
+package lis
+
+import (
+	"LIS/lis"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCredentialProviders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	err := ioutil.WriteFile(path, []byte("from-file\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = lis.FilePassword{Path: path}.Password()
+	if err == nil {
+		t.Errorf("World readable password file is accepted")
+	}
+	os.Chmod(path, 0600)
+	password, err := lis.FilePassword{Path: path}.Password()
+	if err != nil || password != "from-file" {
+		t.Errorf("Password file is read wrong: %q, %v", password, err)
+	}
+
+	t.Setenv("LIS_TEST_SECRET", "from-env")
+	password, err = lis.EnvPassword{Name: "LIS_TEST_SECRET"}.Password()
+	if err != nil || password != "from-env" {
+		t.Errorf("Password variable is read wrong: %q, %v", password, err)
+	}
+	_, err = lis.EnvPassword{Name: "LIS_TEST_UNSET"}.Password()
+	if err == nil {
+		t.Errorf("Unset password variable is accepted")
+	}
+
+	password, err = lis.CommandPassword{Command: "echo from-cmd; echo second line"}.Password()
+	if err != nil || password != "from-cmd" {
+		t.Errorf("Password command is read wrong: %q, %v", password, err)
+	}
+	_, err = lis.CommandPassword{Command: "exit 1"}.Password()
+	if err == nil {
+		t.Errorf("Failed password command is accepted")
+	}
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.WriteString("from-stdin\nshow tue\n")
+	writer.Close()
+	password, err = lis.PromptPassword{In: reader}.Password()
+	if err != nil || password != "from-stdin" {
+		t.Errorf("Piped password is read wrong: %q, %v", password, err)
+	}
+	// the lines after the password are left to the bot
+	rest, _ := ioutil.ReadAll(reader)
+	if string(rest) != "show tue\n" {
+		t.Errorf("Input after the password is consumed: %q", rest)
+	}
+}
@@ -0,0 +1,283 @@
+package lis
+
+import (
+	"LIS/lis"
+	"LIS/lis/fake"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestJobNextRun(t *testing.T) {
+	job := lis.Job{Day: "Tue", Time: "2pm - 7pm", Release: "18:00", DaysBefore: 7}
+	err := job.Prepare()
+	if err != nil {
+		t.Fatalf("Valid job is rejected: %s", err.Error())
+	}
+
+	// Tuesday before the release
+	now := time.Date(2022, 11, 29, 17, 0, 0, 0, time.UTC)
+	release, game, ok := job.NextRun(now, time.UTC)
+	if !ok || !release.Equal(time.Date(2022, 11, 29, 18, 0, 0, 0, time.UTC)) || game.Format("2006-01-02") != "2022-12-06" {
+		t.Errorf("Wrong next run: release %s, game %s", release, game)
+	}
+
+	// Tuesday right after the release moves the run to the next week
+	now = time.Date(2022, 11, 29, 18, 0, 1, 0, time.UTC)
+	release, game, ok = job.NextRun(now, time.UTC)
+	if !ok || !release.Equal(time.Date(2022, 12, 6, 18, 0, 0, 0, time.UTC)) || game.Format("2006-01-02") != "2022-12-13" {
+		t.Errorf("Wrong next run: release %s, game %s", release, game)
+	}
+
+	// the game of 2022-12-06 is already released, so the next one is over the until date
+	over := lis.Job{Day: "Tue", Time: "2pm - 7pm", Release: "18:00", DaysBefore: 7}
+	over.Until = "2022-12-12"
+	over.Prepare()
+	_, _, ok = over.NextRun(now, time.UTC)
+	if ok {
+		t.Errorf("Job is run after its until date")
+	}
+
+	bad := lis.Job{Day: "Someday", Time: "2pm - 7pm"}
+	if bad.Prepare() == nil {
+		t.Errorf("Unknown day is accepted")
+	}
+	bad = lis.Job{Day: "Tue", Time: "2pm - 7pm", Release: "6pm"}
+	if bad.Prepare() == nil {
+		t.Errorf("Wrong release time is accepted")
+	}
+}
+
+func TestDaemonRunJob(t *testing.T) {
+	testsrvr := httptest.NewServer(
+		http.HandlerFunc(mainHandler),
+	)
+	defer testsrvr.Close()
+	instance := lis.NewInstance(testsrvr.URL, "TEST", "TEST", "TEST")
+	err := instance.Authorise()
+	if err != nil {
+		t.Fatal("Auth credentials is not valid for the end user")
+	}
+
+	job := lis.Job{Name: "weekly", Day: "Tue", Time: "9am - 2pm", Release: "00:00", Attempts: 1}
+	err = job.Prepare()
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := lis.NewDaemon(instance, []lis.Job{job}, time.Minute)
+	if err != nil {
+		t.Fatalf("Can not create the daemon: %s", err.Error())
+	}
+	game := time.Date(2022, 11, 29, 0, 0, 0, 0, time.UTC)
+	outcome := daemon.RunJob(job, time.Now(), game)
+	if !outcome.Booked || outcome.Date != "2022-11-29" || outcome.Court == "" {
+		t.Errorf("Job is not booked: %+v", outcome)
+	}
+}
+
+func TestDaemonStopsBeforeRelease(t *testing.T) {
+	server := fake.New()
+	testsrvr := httptest.NewServer(server)
+	defer testsrvr.Close()
+	instance := lis.NewInstance(testsrvr.URL, "TEST", "TEST", "TEST")
+	instance.Authorise()
+
+	// the daemon wakes up at once and waits for the release in two hours
+	job := lis.Job{
+		Recurrence: lis.Recurrence{Days: []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}},
+		Time:       "2pm - 7pm",
+		Release:    time.Now().UTC().Add(2 * time.Hour).Format("15:04"),
+	}
+	err := job.Prepare()
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := lis.NewDaemon(instance, []lis.Job{job}, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		daemon.Run(stop)
+		close(done)
+	}()
+	time.Sleep(200 * time.Millisecond)
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Daemon is not stopped while waiting for the release")
+	}
+	if len(daemon.Outcomes) != 1 || daemon.Outcomes[0].Booked || daemon.Outcomes[0].Error != "daemon is stopped" {
+		t.Errorf("Stopped job is reported wrong: %+v", daemon.Outcomes)
+	}
+	for _, request := range server.Requests() {
+		if request == "POST /bookings" {
+			t.Errorf("Slot is booked after the stop")
+		}
+	}
+}
+
+func TestDaemonRunJobKeepsWeek(t *testing.T) {
+	server := fake.New()
+	testsrvr := httptest.NewServer(server)
+	defer testsrvr.Close()
+	instance := lis.NewInstance(testsrvr.URL, "TEST", "TEST", "TEST")
+	instance.Authorise()
+	instance.SetFaketime("2022-11-29")
+
+	job := lis.Job{Name: "next week", Day: "Tue", Time: "2pm - 7pm", Attempts: 1}
+	err := job.Prepare()
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := lis.NewDaemon(instance, []lis.Job{job}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outcome := daemon.RunJob(job, time.Now(), time.Date(2022, 12, 6, 0, 0, 0, 0, time.UTC))
+	if !outcome.Booked {
+		t.Errorf("Job is not booked: %+v", outcome)
+	}
+	// the job switches the week of its own copy of the session only
+	if instance.GetFakeTime() != "2022-11-29" {
+		t.Errorf("Week of the session is changed by the job: %s", instance.GetFakeTime())
+	}
+}
+
+func TestDaemonCheckFreed(t *testing.T) {
+	server := fake.New()
+	testsrvr := httptest.NewServer(server)
+	defer testsrvr.Close()
+	instance := lis.NewInstance(testsrvr.URL, "TEST", "TEST", "TEST")
+	instance.Authorise()
+	events := make(eventChannel, 4)
+	instance.SetNotifier(events)
+	demo := fakeSchedule(t, testsrvr.URL, "DEMO")
+
+	job := lis.Job{Name: "tuesday", Day: "Tue", Time: "2pm - 7pm", Courts: []string{"Cessna 172"}}
+	err := job.Prepare()
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := lis.NewDaemon(instance, []lis.Job{job}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	games := job.Occurrences(time.Now().In(daemon.Location()), 2)
+	taken, _ := server.Book(360847, 77787, 759165, games[0].Format("2006-01-02"), "Taken")
+	mine, _ := server.Book(123, 77787, 759165, games[1].Format("2006-01-02"), "Mine")
+	if len(daemon.CheckFreed()) != 0 {
+		t.Errorf("Events are sent at the first check")
+	}
+
+	demo.CancelBooking(taken.ID)
+	demo.CancelBooking(mine.ID)
+	sent := daemon.CheckFreed()
+	if len(sent) != 2 {
+		t.Fatalf("Expected 2 events, got %+v", sent)
+	}
+	received := map[string]lis.Event{}
+	for range sent {
+		event := waitEvent(t, events)
+		received[event.Type] = event
+	}
+	freed := received[lis.EventSlotFreed]
+	if freed.Job != "tuesday" || freed.Date != games[0].Format("2006-01-02") || freed.Time != "2pm - 7pm" {
+		t.Errorf("Freed slot is notified wrong: %+v", freed)
+	}
+	cancelled := received[lis.EventBookingCancelled]
+	if cancelled.Job != "tuesday" || cancelled.Date != games[1].Format("2006-01-02") {
+		t.Errorf("Cancelled booking is notified wrong: %+v", cancelled)
+	}
+	if len(daemon.CheckFreed()) != 0 {
+		t.Errorf("Events are sent again")
+	}
+}
+
+func TestDaemonSkipsPollBeforeRelease(t *testing.T) {
+	server := fake.New()
+	testsrvr := httptest.NewServer(server)
+	defer testsrvr.Close()
+	instance := lis.NewInstance(testsrvr.URL, "TEST", "TEST", "TEST")
+	instance.Authorise()
+
+	release := time.Now().UTC().Truncate(time.Minute).Add(time.Minute)
+	if time.Until(release) < time.Second {
+		release = release.Add(time.Minute)
+	}
+	job := lis.Job{
+		Recurrence: lis.Recurrence{Days: []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}},
+		Time:       "2pm - 7pm",
+		Release:    release.Format("15:04"),
+	}
+	err := job.Prepare()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the job wakes up in 150ms, a slow check polled in 100ms would delay it
+	started := time.Now()
+	daemon, err := lis.NewDaemon(instance, []lis.Job{job}, time.Until(release)-150*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon.Poll = 100 * time.Millisecond
+	server.SetLatency(100 * time.Millisecond)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		daemon.Run(stop)
+		close(done)
+	}()
+	time.Sleep(600 * time.Millisecond)
+	close(stop)
+	<-done
+	if len(daemon.Outcomes) != 1 || daemon.Outcomes[0].Started.Sub(started) > 400*time.Millisecond {
+		t.Errorf("Job is delayed by the check: %+v", daemon.Outcomes)
+	}
+}
+
+func TestDaemonJobsSharingRelease(t *testing.T) {
+	server := fake.New()
+	testsrvr := httptest.NewServer(server)
+	defer testsrvr.Close()
+	instance := lis.NewInstance(testsrvr.URL, "TEST", "TEST", "TEST")
+	instance.Authorise()
+
+	// both jobs are released at the start of this minute, the daemon is
+	// started just before it
+	release := time.Now().UTC().Truncate(time.Minute)
+	everyDay := lis.Recurrence{Days: []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}}
+	jobs := []lis.Job{
+		{Name: "a", Recurrence: everyDay, Time: "9am - 2pm", Release: release.Format("15:04"), Attempts: 1},
+		{Name: "b", Recurrence: everyDay, Time: "2pm - 7pm", Release: release.Format("15:04"), Attempts: 1},
+	}
+	for index := range jobs {
+		err := jobs[index].Prepare()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	daemon, err := lis.NewDaemon(instance, jobs, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon.Since = release.Add(-time.Second)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		daemon.Run(stop)
+		close(done)
+	}()
+	time.Sleep(500 * time.Millisecond)
+	close(stop)
+	<-done
+	if len(daemon.Outcomes) != 2 || !daemon.Outcomes[0].Booked || !daemon.Outcomes[1].Booked {
+		t.Errorf("Jobs sharing the release are not all run: %+v", daemon.Outcomes)
+	}
+	if len(server.Bookings()) != 2 {
+		t.Errorf("Expected a booking per job: %+v", server.Bookings())
+	}
+}
@@ -0,0 +1,133 @@
+package lis
+
+import (
+	"LIS/lis"
+	"LIS/lis/fake"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDryRun(t *testing.T) {
+	server, sched := fakeClub(t, "TEST")
+	sched.SetDryRun(true)
+	if sched.Refresh() != nil {
+		t.Fatal("Schedule is not refreshed")
+	}
+	booked := sched.BookPreferredIfPossible([]string{"Piper Archer"}, "Tue", "2pm - 7pm", "To Play")
+	if booked == nil || *booked != "Piper Archer" {
+		t.Fatalf("Dry run doesn't resolve the cell")
+	}
+	for _, request := range server.Requests() {
+		if strings.HasPrefix(request, "POST /book") {
+			t.Errorf("Dry run sends %s", request)
+		}
+	}
+	planned := sched.PlannedRequests()
+	if len(planned) != 2 || planned[0].Endpoint != "booked_time_slots" || planned[1].Endpoint != "bookings" {
+		t.Fatalf("Wrong planned requests: %+v", planned)
+	}
+	slot := lis.BookingTimeSlotRequest{}
+	booking := lis.BookingRequest{}
+	json.Unmarshal([]byte(planned[0].Payload), &slot)
+	json.Unmarshal([]byte(planned[1].Payload), &booking)
+	if slot.BookingDate != "2022-11-29" || slot.TimeSlotID != 759165 || booking.ResourceID != 77791 || booking.BookedByUserID != 123 || booking.Description != "To Play" {
+		t.Errorf("Wrong payloads: %+v", planned)
+	}
+	if booking.BookedTimeSlotID != 0 || !strings.Contains(planned[1].Note, "placeholder") {
+		t.Errorf("Placeholder of the booked time slot is not noted: %+v", planned[1])
+	}
+	if len(sched.PlannedRequests()) != 0 {
+		t.Errorf("Planned requests are returned twice")
+	}
+
+	// taken slots are still refused
+	server.Book(360847, 77787, 759165, "2022-11-29", "Taken")
+	server.Book(360847, 77791, 759165, "2022-11-29", "Taken")
+	sched.Refresh()
+	if sched.BookIfPossible("Tue", "2pm - 7pm", "To Play") != nil {
+		t.Errorf("Dry run books a taken slot")
+	}
+}
+
+func TestDaemonDryRun(t *testing.T) {
+	server := fake.New()
+	testsrvr := httptest.NewServer(server)
+	defer testsrvr.Close()
+	instance := lis.NewInstance(testsrvr.URL, "TEST", "TEST", "TEST")
+	if instance.Authorise() != nil {
+		t.Fatal("Session is not authorised")
+	}
+	job := lis.Job{Name: "weekly", Day: "Wed", Time: "9am - 2pm", Release: "18:00", DaysBefore: 7}
+	if job.Prepare() != nil {
+		t.Fatal("Valid job is rejected")
+	}
+	events := make(eventChannel, 10)
+	instance.SetNotifier(events)
+	daemon, err := lis.NewDaemon(instance, []lis.Job{job}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outcomes := daemon.DryRunJobs()
+	if len(outcomes) != 1 || !outcomes[0].Booked || !outcomes[0].DryRun || outcomes[0].Day != "Wed" {
+		t.Errorf("Job is not rehearsed: %+v", outcomes)
+	}
+	if len(server.Bookings()) != 0 {
+		t.Errorf("Dry run books: %+v", server.Bookings())
+	}
+	select {
+	case event := <-events:
+		t.Errorf("Dry run sends %s", event.Type)
+	default:
+	}
+}
+
+func TestDryRunCancel(t *testing.T) {
+	server, sched := fakeClub(t, "TEST")
+	booking, _ := server.Book(123, 77787, 759165, "2022-11-29", "Mine")
+	sched.SetDryRun(true)
+	err := sched.CancelBooking(booking.ID)
+	if err != nil {
+		t.Fatalf("Dry run fails to cancel: %s", err.Error())
+	}
+	if len(server.Bookings()) != 1 {
+		t.Errorf("Dry run cancels the booking")
+	}
+	planned := sched.PlannedRequests()
+	if len(planned) != 1 || planned[0].Method != "DELETE" || planned[0].Endpoint != fmt.Sprintf("bookings/%d", booking.ID) {
+		t.Errorf("Wrong planned requests: %+v", planned)
+	}
+}
+
+func TestDryRunCommands(t *testing.T) {
+	server, connect := fakeCLI(t, "TEST")
+	home := t.TempDir()
+	now := time.Now()
+	booking, err := server.Book(123, 77787, 759159+2*(int(now.Weekday())+1), now.Format("2006-01-02"), "Mine")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := runCLI(t, home, command("cancel", connect, "-o", "json", "--dry-run", "-i", strconv.Itoa(booking.ID))...)
+	cancelled := lis.CancelResult{}
+	json.Unmarshal([]byte(result.stdout), &cancelled)
+	if result.code != 0 || !cancelled.DryRun || len(cancelled.Requests) != 1 || cancelled.Requests[0].Method != "DELETE" {
+		t.Errorf("Wrong dry run of cancel (%d): %s", result.code, result.stdout)
+	}
+	if len(server.Bookings()) != 1 {
+		t.Errorf("Booking is cancelled in the dry run")
+	}
+
+	for _, name := range []string{"serve", "bot", "show"} {
+		result = runCLI(t, home, command(name, connect, "-o", "json", "--dry-run")...)
+		failure := lis.ErrorResult{}
+		json.Unmarshal([]byte(result.stdout), &failure)
+		if result.code != 1 || !strings.Contains(failure.Error, "--dry-run") {
+			t.Errorf("Dry run of %s is not refused (%d): %s", name, result.code, result.stdout)
+		}
+	}
+}
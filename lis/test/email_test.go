@@ -0,0 +1,155 @@
+package lis
+
+import (
+	"LIS/lis"
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type sentEmail struct {
+	from string
+	to   []string
+	data string
+}
+
+// smtpStandIn accepts the emails on a local port and passes them to the
+// channel. It speaks just enough SMTP for net/smtp.
+func smtpStandIn(t *testing.T) (string, int, chan sentEmail) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	emails := make(chan sentEmail, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+				reply("220 localhost ready")
+				email := sentEmail{}
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					command := strings.ToUpper(strings.TrimSpace(line))
+					switch {
+					case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+						reply("250 localhost")
+					case strings.HasPrefix(command, "MAIL FROM:"):
+						email.from = strings.Trim(strings.TrimSpace(line)[10:], "<>")
+						reply("250 OK")
+					case strings.HasPrefix(command, "RCPT TO:"):
+						email.to = append(email.to, strings.Trim(strings.TrimSpace(line)[8:], "<>"))
+						reply("250 OK")
+					case command == "DATA":
+						reply("354 go ahead")
+						var data strings.Builder
+						for {
+							line, err := reader.ReadString('\n')
+							if err != nil || line == ".\r\n" {
+								break
+							}
+							data.WriteString(line)
+						}
+						email.data = data.String()
+						emails <- email
+						email = sentEmail{}
+						reply("250 OK")
+					case command == "QUIT":
+						reply("221 bye")
+						return
+					default:
+						reply("250 OK")
+					}
+				}
+			}(conn)
+		}
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	number, _ := strconv.Atoi(port)
+	return host, number, emails
+}
+
+func TestEmailNotifier(t *testing.T) {
+	host, port, emails := smtpStandIn(t)
+	config := lis.SMTPConfig{Host: host, Port: port, From: "lis@club.example", Partners: []string{"bob@club.example"}}
+	user := lis.User{Email: "alice@club.example", EmailPreferences: true}
+	notifier := lis.NewEmailNotifier(config, user)
+
+	err := notifier.Notify(lis.Event{Type: lis.EventBookingSucceeded, Day: "Tue", Date: "2022-11-29", Time: "2pm - 7pm", Court: "Cessna 172"})
+	if err != nil {
+		t.Fatalf("Email is not sent: %s", err.Error())
+	}
+	email := <-emails
+	if email.from != "lis@club.example" || len(email.to) != 2 || email.to[0] != "alice@club.example" || email.to[1] != "bob@club.example" {
+		t.Errorf("Email is sent to wrong recipients: %+v", email)
+	}
+	if !strings.Contains(email.data, "Subject: Booked Tue 2022-11-29 2pm - 7pm on Cessna 172\r\n") {
+		t.Errorf("Wrong email:\n%s", email.data)
+	}
+
+	err = notifier.Notify(lis.Event{Type: lis.EventBookingFailed, Day: "Tue", Date: "2022-11-29", Time: "2pm - 7pm", Error: "not booked in 10 attempts"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	email = <-emails
+	if len(email.to) != 1 || !strings.Contains(email.data, "Reason: not booked in 10 attempts") {
+		t.Errorf("Failure is emailed wrong: %+v", email)
+	}
+
+	// changes of the bookings need BookingChangeEmails
+	err = notifier.Notify(lis.Event{Type: lis.EventBookingCancelled, Day: "Tue", Date: "2022-11-29"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.BookingChangeEmails = true
+	err = lis.NewEmailNotifier(config, user).Notify(lis.Event{Type: lis.EventBookingCancelled, Day: "Tue", Date: "2022-11-29", Time: "2pm - 7pm"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	email = <-emails
+	if !strings.Contains(email.data, "Subject: Booking of Tue 2022-11-29 2pm - 7pm is cancelled") {
+		t.Errorf("Change is emailed wrong or the unwanted one is sent:\n%s", email.data)
+	}
+
+	// no emails for the user without EmailPreferences, partners still get the bookings
+	config.Partners = nil
+	user.EmailPreferences = false
+	err = lis.NewEmailNotifier(config, user).Notify(lis.Event{Type: lis.EventBookingSucceeded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case email = <-emails:
+		t.Errorf("Email is sent against the preferences: %+v", email)
+	default:
+	}
+}
+
+func TestEmailOfTeammate(t *testing.T) {
+	host, port, emails := smtpStandIn(t)
+	config := lis.SMTPConfig{Host: host, Port: port, From: "lis@club.example"}
+	organiser := lis.User{ID: 360847, Username: "DEMO", Email: "alice@club.example", EmailPreferences: true}
+	teammate := lis.User{ID: 123, Username: "TEST", Email: "carol@club.example", EmailPreferences: true}
+	notifier := lis.NewEmailNotifier(config, organiser)
+	notifier.SetUsers([]lis.User{organiser, teammate})
+
+	err := notifier.Notify(lis.Event{Type: lis.EventBookingSucceeded, Day: "Tue", Date: "2022-11-29", Time: "2pm - 7pm", Court: "Cessna 172", For: "test"})
+	if err != nil {
+		t.Fatalf("Email is not sent: %s", err.Error())
+	}
+	email := <-emails
+	if len(email.to) != 2 || email.to[0] != "alice@club.example" || email.to[1] != "carol@club.example" || !strings.Contains(email.data, "For: test") {
+		t.Errorf("Booking for the teammate is emailed wrong: %+v", email)
+	}
+}
@@ -0,0 +1,114 @@
+package lis
+
+import (
+	"LIS/lis"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExport(t *testing.T) {
+	testsrvr := httptest.NewServer(
+		http.HandlerFunc(mainHandler),
+	)
+	defer testsrvr.Close()
+	instance := lis.NewInstance(testsrvr.URL, "TEST", "TEST", "TEST")
+	err := instance.Authorise()
+	if err != nil {
+		t.Fatal("Auth credentials is not valid for the end user")
+	}
+	sched, err := lis.NewSchedule(instance)
+	if err != nil {
+		t.Fatalf("Can not create schedule obj with err: %s", err.Error())
+	}
+	instance.SetFaketime("2022-11-29")
+	err = sched.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = lis.WriteScheduleTable(&out, "csv", sched.RenderSchedule())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if lines[0] != "date,day,court,time,status,booker,description" {
+		t.Errorf("Wrong header: %s", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "2022-11-28,Mon,") || !strings.HasPrefix(lines[len(lines)-1], "2022-12-04,Sun,") {
+		t.Errorf("Rows are not ordered by date: %s ... %s", lines[1], lines[len(lines)-1])
+	}
+	if !strings.Contains(out.String(), "2022-12-04,Sun,Cessna 172,9am - 11:30pm,booked,Demo User,1234\n") {
+		t.Errorf("Booked slot is missing:\n%s", out.String())
+	}
+
+	out.Reset()
+	err = lis.WriteBookingsTable(&out, "tsv", sched.Bookings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "date\tday\tcourt\ttime\tbooking_id\tbooker\tdescription\n" +
+		"2022-12-04\tSun\tCessna 172\t9am - 11:30pm\t11764275\tDemo User\t1234\n"
+	if out.String() != expected {
+		t.Errorf("Wrong bookings table:\n%s", out.String())
+	}
+	if len(sched.MyBookings()) != 0 {
+		t.Errorf("Bookings of other users are mine")
+	}
+
+	out.Reset()
+	attempt := lis.Attempt{
+		Source:      "snipe",
+		Date:        "2022-11-28",
+		Day:         "Mon",
+		Time:        "2pm - 7pm",
+		AttemptedAt: time.Date(2022, 11, 21, 18, 0, 0, 0, time.UTC),
+		LatencyMS:   map[string]float64{"booked_time_slots": 10, "bookings": 5.5},
+		Failure:     "slot is taken, \"sorry\"",
+	}
+	err = lis.WriteHistoryTable(&out, "csv", []lis.Attempt{attempt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(out.String(), "2022-11-21T18:00:00Z,snipe,2022-11-28,Mon,,2pm - 7pm,false,,15.5,\"slot is taken, \"\"sorry\"\"\"\n") {
+		t.Errorf("Wrong history table:\n%s", out.String())
+	}
+
+	err = lis.WriteBookingsTable(&out, "xlsx", nil)
+	if err == nil {
+		t.Errorf("Unknown format is accepted")
+	}
+}
+
+func TestExportEscaping(t *testing.T) {
+	server, sched := fakeClub(t, "TEST")
+	server.AddUser(lis.User{ID: 400, Username: "PRIV", Name: "Private Player", MemberDetailsPrivate: true}, "PRIV")
+	server.AddUser(lis.User{ID: 123, Username: "TEST", Name: "Test Player", MemberDetailsPrivate: true}, "TEST")
+	private, _ := server.Book(400, 77787, 759165, "2022-11-29", `=HYPERLINK("http://evil.example","x")`)
+	mine, _ := server.Book(123, 77791, 759165, "2022-11-29", "@SUM(A1)")
+	other, _ := server.Book(360847, 77787, 759167, "2022-11-30", "-1+2")
+	sched.Refresh()
+
+	var out bytes.Buffer
+	err := lis.WriteBookingsTable(&out, "csv", sched.Bookings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		fmt.Sprintf(`2022-11-29,Tue,Cessna 172,2pm - 7pm,%d,PRIV,"'=HYPERLINK(""http://evil.example"",""x"")"`, private.ID),
+		fmt.Sprintf("2022-11-29,Tue,Piper Archer,2pm - 7pm,%d,Test Player,'@SUM(A1)", mine.ID),
+		fmt.Sprintf("2022-11-30,Wed,Cessna 172,2pm - 7pm,%d,Demo User,'-1+2", other.ID),
+	} {
+		if !strings.Contains(out.String(), expected+"\n") {
+			t.Errorf("Row %s is missing:\n%s", expected, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "Private Player") {
+		t.Errorf("Private name is exported:\n%s", out.String())
+	}
+}
@@ -0,0 +1,105 @@
+package lis
+
+import (
+	"LIS/lis"
+	"LIS/lis/fake"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeClub starts the fake server and authorises the user on it, the week
+// of the session is 2022-11-29.
+func fakeClub(t *testing.T, username string) (*fake.Server, *lis.Schedule) {
+	server := fake.New()
+	testsrvr := httptest.NewServer(server)
+	t.Cleanup(testsrvr.Close)
+	return server, fakeSchedule(t, testsrvr.URL, username)
+}
+
+func fakeSchedule(t *testing.T, endpoint string, username string) *lis.Schedule {
+	instance := lis.NewInstance(endpoint, username, username, "TEST")
+	err := instance.Authorise()
+	if err != nil {
+		t.Fatalf("%s is not authorised: %s", username, err.Error())
+	}
+	instance.SetFaketime("2022-11-29")
+	sched, err := lis.NewSchedule(instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sched
+}
+
+func TestFakeServer(t *testing.T) {
+	server := fake.New()
+	testsrvr := httptest.NewServer(server)
+	defer testsrvr.Close()
+	if lis.NewInstance(testsrvr.URL, "TEST", "wrong", "TEST").Authorise() == nil {
+		t.Errorf("Wrong password is accepted")
+	}
+	sched := fakeSchedule(t, testsrvr.URL, "TEST")
+	if sched.Refresh() != nil {
+		t.Fatal("Schedule is not refreshed")
+	}
+
+	// the slot taken after the refresh is refused by the server
+	_, err := server.Book(360847, 77787, 759163, "2022-11-28", "Taken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sched.BookCourtIfPossible("Cessna 172", "Mon", "2pm - 7pm", "To Play") != nil {
+		t.Errorf("Taken slot is booked")
+	}
+	booked := sched.BookIfPossible("Mon", "2pm - 7pm", "To Play")
+	if booked == nil || *booked != "Piper Archer" {
+		t.Fatalf("Free court is not booked")
+	}
+
+	// the booking is seen by the others and in the schedule
+	other := fakeSchedule(t, testsrvr.URL, "DEMO")
+	if other.SlotStatus(time.Date(2022, 11, 28, 0, 0, 0, 0, time.UTC), []string{"Piper Archer"}, "2pm - 7pm") != "booked" {
+		t.Errorf("Booking is not seen by the others")
+	}
+	sched.Refresh()
+	mine := sched.MyBookings()
+	if len(mine) != 1 || mine[0].Court != "Piper Archer" || mine[0].Date != "2022-11-28" {
+		t.Fatalf("Booking is not stored: %+v", mine)
+	}
+	if sched.CancelBooking(mine[0].ID) != nil {
+		t.Errorf("Booking is not cancelled")
+	}
+	if len(server.Bookings()) != 1 {
+		t.Errorf("Cancelled booking is kept: %+v", server.Bookings())
+	}
+
+	// the bookings of the other weeks are not shown
+	if other.SlotStatus(time.Date(2022, 12, 5, 0, 0, 0, 0, time.UTC), []string{"Cessna 172"}, "2pm - 7pm") != "free" {
+		t.Errorf("Booking of the other week is shown")
+	}
+}
+
+func TestFakeServerFailures(t *testing.T) {
+	server, sched := fakeClub(t, "TEST")
+	server.Fail(fake.Failure{Method: "POST", Path: "/bookings", Status: 503, Times: 1})
+	sched.Refresh()
+	if sched.BookCourtIfPossible("Cessna 172", "Tue", "9am - 2pm", "To Play") != nil {
+		t.Errorf("Injected failure is ignored")
+	}
+	if sched.BookCourtIfPossible("Cessna 172", "Tue", "9am - 2pm", "To Play") == nil {
+		t.Errorf("Failure is injected more times than asked")
+	}
+
+	server.SetLatency(50 * time.Millisecond)
+	started := time.Now()
+	sched.Refresh()
+	if time.Since(started) < 250*time.Millisecond {
+		t.Errorf("Latency is not applied")
+	}
+
+	server.SetLatency(0)
+	server.ExpireSessions()
+	if sched.Refresh() == nil {
+		t.Errorf("Expired session is served")
+	}
+}
@@ -0,0 +1,218 @@
+package lis
+
+import (
+	"LIS/lis"
+	"LIS/lis/fake"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func countPosts(server *fake.Server, path string) int {
+	posts := 0
+	for _, request := range server.Requests() {
+		if request == "POST "+path {
+			posts++
+		}
+	}
+	return posts
+}
+
+func TestDuplicateGuard(t *testing.T) {
+	server, sched := fakeClub(t, "TEST")
+	server.Book(123, 77787, 759165, "2022-11-29", "Mine")
+	server.Book(123, 77787, 759166, "2022-11-30", "Mine")
+	sched.Refresh()
+
+	booked := sched.BookCourtIfPossible("Cessna 172", "Tue", "2pm - 7pm", "To Play")
+	if booked == nil || *booked != "Cessna 172" || countPosts(server, "/bookings") != 0 {
+		t.Errorf("My booking is not found or booked again")
+	}
+	booked = sched.BookIfPossible("Tue", "2pm - 7pm", "To Play")
+	if booked == nil || *booked != "Cessna 172" || countPosts(server, "/bookings") != 0 {
+		t.Errorf("My booking on any court is not found")
+	}
+	// the other courts are allowed unless the guard covers any court
+	booked = sched.BookPreferredIfPossible([]string{"Piper Archer"}, "Tue", "2pm - 7pm", "To Play")
+	if booked == nil || *booked != "Piper Archer" || countPosts(server, "/bookings") != 1 {
+		t.Errorf("Other court is not booked")
+	}
+	sched.SetDuplicateGuard(true)
+	booked = sched.BookPreferredIfPossible([]string{"Piper Archer"}, "Wed", "9am - 2pm", "To Play")
+	if booked == nil || *booked != "Cessna 172" || countPosts(server, "/bookings") != 1 {
+		t.Errorf("Booking on any court is not guarded")
+	}
+}
+
+func TestIdempotentBooking(t *testing.T) {
+	server := fake.New()
+	var lose int32 = 1
+	testsrvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.Path == "/bookings" && atomic.CompareAndSwapInt32(&lose, 1, 0) {
+			// booked, but the response never arrives
+			server.ServeHTTP(httptest.NewRecorder(), r)
+			panic(http.ErrAbortHandler)
+		}
+		server.ServeHTTP(w, r)
+	}))
+	defer testsrvr.Close()
+	sched := fakeSchedule(t, testsrvr.URL, "TEST")
+	path := filepath.Join(t.TempDir(), "ledger.json")
+	sched.SetLedger(lis.NewBookingLedger(path))
+	sched.Refresh()
+
+	if sched.BookCourtIfPossible("Cessna 172", "Thu", "9am - 2pm", "To Play") != nil {
+		t.Fatal("Lost response is reported as booked")
+	}
+	entry, ok, err := lis.NewBookingLedger(path).Get("123/2022-12-01/9am - 2pm")
+	if err != nil || !ok || !entry.Pending || entry.Court != "Cessna 172" {
+		t.Fatalf("Lost booking is not pending in the ledger: %+v %v", entry, err)
+	}
+
+	// the retry with the stale schedule finds the lost booking
+	booked := sched.BookCourtIfPossible("Cessna 172", "Thu", "9am - 2pm", "To Play")
+	if booked == nil || *booked != "Cessna 172" {
+		t.Errorf("Lost booking is not found")
+	}
+	if len(server.Bookings()) != 1 || countPosts(server, "/bookings") != 1 {
+		t.Errorf("Slot is booked twice: %+v", server.Bookings())
+	}
+	entry, _, _ = lis.NewBookingLedger(path).Get("123/2022-12-01/9am - 2pm")
+	if entry.Pending || entry.BookingID != server.Bookings()[0].ID {
+		t.Errorf("Found booking is not stored in the ledger: %+v", entry)
+	}
+
+	// a refused booking had no effect and is forgotten
+	server.Fail(fake.Failure{Method: "POST", Path: "/bookings", Status: 409, Times: 1})
+	if sched.BookCourtIfPossible("Cessna 172", "Fri", "9am - 2pm", "To Play") != nil {
+		t.Errorf("Refused booking is reported as booked")
+	}
+	_, ok, _ = lis.NewBookingLedger(path).Get("123/2022-12-02/9am - 2pm")
+	if ok {
+		t.Errorf("Refused booking is kept in the ledger")
+	}
+}
+
+func TestLedgerOfOtherProcess(t *testing.T) {
+	server, sched := fakeClub(t, "TEST")
+	path := filepath.Join(t.TempDir(), "ledger.json")
+	ledger := lis.NewBookingLedger(path)
+	sched.SetLedger(ledger)
+	sched.Refresh()
+	other := os.Getpid() + 1
+
+	// another process waits for the response of its booking
+	ledger.Put(lis.LedgerEntry{Key: "123/2022-11-30/2pm - 7pm", Date: "2022-11-30", Time: "2pm - 7pm", Court: "Piper Archer", Pending: true, PID: other})
+	if sched.BookCourtIfPossible("Cessna 172", "Wed", "2pm - 7pm", "To Play") != nil || countPosts(server, "/booked_time_slots") != 0 {
+		t.Errorf("Slot booked by another process is booked again")
+	}
+	// ...for too long, its response is lost
+	ledger.Put(lis.LedgerEntry{Key: "123/2022-11-30/2pm - 7pm", Date: "2022-11-30", Time: "2pm - 7pm", Court: "Piper Archer", Pending: true, PID: other, At: time.Now().Add(-time.Hour)})
+	booked := sched.BookCourtIfPossible("Cessna 172", "Wed", "2pm - 7pm", "To Play")
+	if booked == nil || sched.AlreadyHeld() || countPosts(server, "/bookings") != 1 {
+		t.Errorf("Slot of the lost response is not booked")
+	}
+
+	// another process has booked the slot since the schedule was read
+	booking, _ := server.Book(123, 77791, 759169, "2022-12-01", "Mine")
+	ledger.Put(lis.LedgerEntry{Key: "123/2022-12-01/2pm - 7pm", Date: "2022-12-01", Time: "2pm - 7pm", Court: "Piper Archer", BookingID: booking.ID, PID: other})
+	sched.SetDuplicateGuard(true)
+	booked = sched.BookCourtIfPossible("Cessna 172", "Thu", "2pm - 7pm", "To Play")
+	if booked == nil || *booked != "Piper Archer" || !sched.AlreadyHeld() || countPosts(server, "/bookings") != 1 {
+		t.Errorf("Booking of another process is not found")
+	}
+
+	// the file is replaced whole, no temporary file is left
+	temporary, _ := filepath.Glob(path + ".*.tmp")
+	if len(temporary) != 0 {
+		t.Errorf("Temporary files are left: %v", temporary)
+	}
+}
+
+func TestAlreadyHeld(t *testing.T) {
+	server := fake.New()
+	testsrvr := httptest.NewServer(server)
+	defer testsrvr.Close()
+	server.Book(123, 77787, 759165, "2022-11-29", "Mine")
+	instance := lis.NewInstance(testsrvr.URL, "TEST", "TEST", "TEST")
+	instance.Authorise()
+	instance.SetFaketime("2022-11-29")
+	events := make(eventChannel, 4)
+	instance.SetNotifier(events)
+	sched, err := lis.NewSchedule(instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := lis.NewHistory(filepath.Join(t.TempDir(), "history.jsonl"))
+	sched.SetHistory(history, "book")
+	sched.Refresh()
+
+	booked := sched.BookCourtIfPossible("Cessna 172", "Tue", "2pm - 7pm", "To Play")
+	if booked == nil || *booked != "Cessna 172" || !sched.AlreadyHeld() {
+		t.Errorf("Held slot is not reported as held")
+	}
+	if sched.Watch([]string{"Cessna 172"}, "Tue", "2pm - 7pm", "To Play", time.Millisecond, time.Second) == nil || !sched.AlreadyHeld() {
+		t.Errorf("Held slot is not returned by watch")
+	}
+	if sched.Snipe(time.Now(), []string{"Cessna 172"}, "Tue", "2pm - 7pm", "To Play", time.Millisecond, 2) == nil || !sched.AlreadyHeld() {
+		t.Errorf("Held slot is not returned by snipe")
+	}
+	if sched.Watch([]string{"Cessna 172"}, "Wed", "2pm - 7pm", "To Play", time.Millisecond, time.Second) == nil || sched.AlreadyHeld() {
+		t.Errorf("New booking is reported as held")
+	}
+
+	daemon, err := lis.NewDaemon(instance, nil, time.Minute)
+	if err != nil {
+		t.Fatalf("Can not create the daemon: %s", err.Error())
+	}
+	daemon.History = history
+	job := lis.Job{Name: "weekly", Day: "Tue", Time: "2pm - 7pm", Courts: []string{"Cessna 172"}, Release: "00:00", Attempts: 1}
+	job.Prepare()
+	outcome := daemon.RunJob(job, time.Now(), time.Date(2022, 11, 29, 0, 0, 0, 0, time.UTC))
+	if outcome.Booked || !outcome.AlreadyHeld || outcome.Court != "Cessna 172" || outcome.Error != "" {
+		t.Errorf("Held slot is a job outcome of its own: %+v", outcome)
+	}
+
+	instance.Flush(time.Second)
+	// only the new booking is notified and recorded
+	if event := waitEvent(t, events); event.Date != "2022-11-30" || len(events) != 0 {
+		t.Errorf("Held slot is notified: %+v, %d more", event, len(events))
+	}
+	attempts, _ := history.Load()
+	if len(attempts) != 1 || attempts[0].Date != "2022-11-30" {
+		t.Errorf("Held slot is recorded: %+v", attempts)
+	}
+}
+
+func TestLedgerSharedByProcesses(t *testing.T) {
+	server, connect := fakeCLI(t, "TEST")
+	// the requests are slow enough for the processes to overlap
+	server.SetLatency(100 * time.Millisecond)
+	home := t.TempDir()
+
+	var wait sync.WaitGroup
+	results := make([]lis.BookingResult, 2)
+	codes := make([]int, 2)
+	for number, court := range []string{"Cessna 172", "Piper Archer"} {
+		wait.Add(1)
+		go func(number int, court string) {
+			defer wait.Done()
+			result := runCLI(t, home, command("book", connect, "-o", "json", "--guard-any-court", "-d", "Tue", "-t", "2pm - 7pm", "-c", court)...)
+			json.Unmarshal([]byte(result.stdout), &results[number])
+			codes[number] = result.code
+		}(number, court)
+	}
+	wait.Wait()
+	if len(server.Bookings()) != 1 {
+		t.Fatalf("Slot is booked by both processes: %+v", server.Bookings())
+	}
+	if codes[0] != 0 || codes[1] != 0 || results[0].Booked == results[1].Booked || results[0].AlreadyHeld == results[1].AlreadyHeld {
+		t.Errorf("One process must book and the other find the booking: %d %+v, %d %+v", codes[0], results[0], codes[1], results[1])
+	}
+}
@@ -0,0 +1,105 @@
+package lis
+
+import (
+	"LIS/lis"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	testsrvr := httptest.NewServer(
+		http.HandlerFunc(mainHandler),
+	)
+	defer testsrvr.Close()
+	instance := lis.NewInstance(testsrvr.URL, "TEST", "TEST", "TEST")
+	err := instance.Authorise()
+	if err != nil {
+		t.Fatal("Auth credentials is not valid for the end user")
+	}
+	sched, err := lis.NewSchedule(instance)
+	if err != nil {
+		t.Fatalf("Can not create schedule obj with err: %s", err.Error())
+	}
+	history := lis.NewHistory(filepath.Join(t.TempDir(), "lis", "history.jsonl"))
+	sched.SetHistory(history, "book")
+
+	instance.SetFaketime("2022-11-29")
+	sched.Refresh()
+	if sched.BookCourtIfPossible("Cessna 172", "Mon", "2pm - 7pm", "To Play") == nil {
+		t.Errorf("Failed to book the room")
+	}
+	if sched.BookCourtIfPossible("Cessna 172", "Sun", "9am - 11:30pm", "To Play") != nil {
+		t.Errorf("Booked slot is booked again")
+	}
+
+	attempts, err := history.Load()
+	if err != nil {
+		t.Fatalf("Can not load the history: %s", err.Error())
+	}
+	if len(attempts) != 2 {
+		t.Fatalf("Expected 2 attempts, got %d", len(attempts))
+	}
+	won := attempts[0]
+	if !won.Booked || won.BookingID != 11764275 || won.Source != "book" || won.Date != "2022-11-28" {
+		t.Errorf("Successful attempt is recorded wrong: %+v", won)
+	}
+	if _, ok := won.LatencyMS["bookings"]; !ok {
+		t.Errorf("Latency of the POST is not recorded: %+v", won.LatencyMS)
+	}
+	if attempts[1].Booked || attempts[1].Failure == "" {
+		t.Errorf("Failed attempt is recorded wrong: %+v", attempts[1])
+	}
+
+	stats := lis.Stats(attempts)
+	if len(stats.Sources) != 1 || stats.Sources[0].WinRate != 0.5 {
+		t.Errorf("Wrong source stats: %+v", stats.Sources)
+	}
+	if len(stats.Courts) != 1 || stats.Courts[0].Lost != 1 || stats.Courts[0].Booked != 1 {
+		t.Errorf("Wrong court stats: %+v", stats.Courts)
+	}
+	if len(lis.HistoryFilter{Failed: true}.Apply(attempts)) != 1 {
+		t.Errorf("Failed filter is not applied")
+	}
+}
+
+func TestHistoryRecordsOncePerBooking(t *testing.T) {
+	server, sched := fakeClub(t, "TEST")
+	history := lis.NewHistory(filepath.Join(t.TempDir(), "history.jsonl"))
+	sched.SetHistory(history, "snipe")
+	server.Book(360847, 77787, 759165, "2022-11-29", "Taken")
+	server.Book(360847, 77791, 759165, "2022-11-29", "Taken")
+	sched.Refresh()
+
+	courts := []string{"Cessna 172", "Piper Archer", ""}
+	if sched.BookPreferredIfPossible(courts, "Tue", "2pm - 7pm", "To Play") != nil {
+		t.Fatalf("Taken slot is booked")
+	}
+	if sched.Snipe(time.Now(), courts, "Tue", "2pm - 7pm", "To Play", time.Millisecond, 3) != nil {
+		t.Fatalf("Taken slot is sniped")
+	}
+	attempts, err := history.Load()
+	if err != nil {
+		t.Fatalf("Can not load the history: %s", err.Error())
+	}
+	if len(attempts) != 2 {
+		t.Fatalf("Expected an attempt per call, got %d: %+v", len(attempts), attempts)
+	}
+	for _, attempt := range attempts {
+		if attempt.Booked || attempt.Court != "Cessna 172" || strings.Join(attempt.Courts, ",") != "Cessna 172,Piper Archer,any" {
+			t.Errorf("Courts tried are recorded wrong: %+v", attempt)
+		}
+	}
+
+	if sched.BookPreferredIfPossible([]string{"Cessna 172", "Piper Archer"}, "Wed", "2pm - 7pm", "To Play") == nil {
+		t.Fatalf("Free slot is not booked")
+	}
+	attempts, _ = history.Load()
+	won := attempts[len(attempts)-1]
+	if len(attempts) != 3 || !won.Booked || won.Failure != "" || strings.Join(won.Courts, ",") != "Cessna 172" {
+		t.Errorf("Booking is recorded wrong: %+v", attempts)
+	}
+}
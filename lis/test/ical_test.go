@@ -0,0 +1,165 @@
+package lis
+
+import (
+	"LIS/lis"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSlotTime(t *testing.T) {
+	cases := []struct {
+		slot       string
+		start, end time.Duration
+	}{
+		{"9am - 2pm", 9 * time.Hour, 14 * time.Hour},
+		{"11:30am - 2pm", 11*time.Hour + 30*time.Minute, 14 * time.Hour},
+		{"4:30pm - 7pm", 16*time.Hour + 30*time.Minute, 19 * time.Hour},
+		{"18:00 - 19:30", 18 * time.Hour, 19*time.Hour + 30*time.Minute},
+	}
+	for _, c := range cases {
+		start, end, err := lis.ParseSlotTime(c.slot)
+		if err != nil || start != c.start || end != c.end {
+			t.Errorf("Slot %q is parsed as %s - %s (%v)", c.slot, start, end, err)
+		}
+	}
+	for _, slot := range []string{"noon", "2pm - 9am", "9am - late"} {
+		_, _, err := lis.ParseSlotTime(slot)
+		if err == nil {
+			t.Errorf("Wrong slot %q is parsed", slot)
+		}
+	}
+}
+
+func TestICS(t *testing.T) {
+	zone := time.FixedZone("CET", 3600)
+	events := lis.BookingEvents([]lis.BookingDetails{
+		{ID: 42, Court: "Court 1", Date: "2022-12-04", Day: "Sun", Time: "6pm - 7pm", Description: "Game, with Bob; " + strings.Repeat("long ", 20)},
+		{ID: 43, Court: "Court 2", Date: "2022-12-05", Day: "Mon", Time: "whenever"},
+	}, zone)
+	if len(events) != 1 || events[0].UID != "booking-42@lis" {
+		t.Fatalf("Wrong events: %+v", events)
+	}
+
+	calendar, err := lis.NewCalendar(filepath.Join(t.TempDir(), "calendar.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err = calendar.Merge(events, "2022-11-28", "2022-12-04", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	lis.WriteICS(&out, "Squash", events)
+	ics := out.String()
+	for _, line := range []string{"UID:booking-42@lis", "DTSTART:20221204T170000Z", "DTEND:20221204T180000Z", "STATUS:CONFIRMED", "SEQUENCE:0"} {
+		if !strings.Contains(ics, line+"\r\n") {
+			t.Errorf("%s is missing in\n%s", line, ics)
+		}
+	}
+	if !strings.Contains(ics, `DESCRIPTION:Game\, with Bob\; long`) {
+		t.Errorf("Description is not escaped:\n%s", ics)
+	}
+	for _, line := range strings.Split(ics, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("Line is not folded: %q", line)
+		}
+	}
+
+	// the booking is gone from the covered week, so it's cancelled
+	events, err = calendar.Merge(nil, "2022-11-28", "2022-12-04", false)
+	if err != nil || len(events) != 1 || !events[0].Cancelled || events[0].Sequence != 1 {
+		t.Fatalf("Booking is not cancelled: %+v %v", events, err)
+	}
+	out.Reset()
+	lis.WriteICS(&out, "Squash", events)
+	if !strings.Contains(out.String(), "STATUS:CANCELLED\r\n") {
+		t.Errorf("Cancelled status is missing:\n%s", out.String())
+	}
+}
+
+func TestCalendarFeed(t *testing.T) {
+	testsrvr := httptest.NewServer(
+		http.HandlerFunc(mainHandler),
+	)
+	defer testsrvr.Close()
+	instance := lis.NewInstance(testsrvr.URL, "TEST", "TEST", "TEST")
+	err := instance.Authorise()
+	if err != nil {
+		t.Fatal("Auth credentials is not valid for the end user")
+	}
+	instance.SetFaketime("2022-11-29")
+	export := lis.CalendarExport{Weeks: 1, All: true, Location: time.UTC}
+	feed, err := lis.NewCalendarFeed(instance, "secret", export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := httptest.NewServer(feed)
+	defer api.Close()
+
+	resp := apiRequest(t, api, "GET", "/calendar.ics?token=wrong", "", nil)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Wrong token is accepted: %d", resp.StatusCode)
+	}
+
+	resp = apiRequest(t, api, "GET", "/calendar.ics?token=secret", "", nil)
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.Header.Get("Content-Type") != "text/calendar; charset=utf-8" || !bytes.HasPrefix(body, []byte("BEGIN:VCALENDAR\r\n")) {
+		t.Fatalf("Feed is not served: %d %s", resp.StatusCode, body)
+	}
+	for _, line := range []string{"UID:slot-77787-759174-2022-12-04@lis", "DTSTART:20221204T090000Z", "DTEND:20221204T233000Z", "LOCATION:Cessna 172"} {
+		if !bytes.Contains(body, []byte(line+"\r\n")) {
+			t.Errorf("%s is missing in\n%s", line, body)
+		}
+	}
+	if instance.GetFakeTime() != "2022-11-29" {
+		t.Errorf("Feed changed the week of the session: %s", instance.GetFakeTime())
+	}
+}
+
+func TestCalendarKinds(t *testing.T) {
+	calendar, err := lis.NewCalendar(filepath.Join(t.TempDir(), "calendar.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := lis.ScheduleEvents([]lis.TimeTable{{Name: "Cessna 172", ID: 77787, Days: []lis.TimeTableDay{{Day: "Tue", Date: "2022-11-29", Cells: []lis.TimeTableCell{
+		{Time: "9am - 2pm", Booked: true, ID: 759160},
+		{Time: "2pm - 7pm", Booked: true, Mine: true, ID: 759165, BookingID: 42},
+	}}}}}, time.UTC)
+	_, err = calendar.Merge(events, "2022-11-28", "2022-12-04", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the export of my bookings doesn't see the slots of the others
+	mine, _ := calendar.Merge(lis.BookingEvents([]lis.BookingDetails{{ID: 42, Court: "Cessna 172", Date: "2022-11-29", Time: "2pm - 7pm"}}, time.UTC), "2022-11-28", "2022-12-04", false)
+	if len(mine) != 1 || mine[0].UID != "booking-42@lis" || mine[0].Cancelled {
+		t.Errorf("Export of my bookings has the other slots: %+v", mine)
+	}
+	all, _ := calendar.Merge(events, "2022-11-28", "2022-12-04", true)
+	if len(all) != 2 || all[0].Cancelled || all[1].Cancelled {
+		t.Errorf("Other slots are cancelled by the export of my bookings: %+v", all)
+	}
+
+	// the weeks before the export are forgotten
+	all, _ = calendar.Merge(nil, "2022-12-05", "2022-12-11", true)
+	if len(all) != 0 {
+		t.Errorf("Past events are kept: %+v", all)
+	}
+}
+
+func TestSlotEventOnClockChange(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("No timezone database")
+	}
+	events := lis.BookingEvents([]lis.BookingDetails{{ID: 1, Court: "Court 1", Date: "2023-03-26", Time: "9am - 2pm"}}, london)
+	if len(events) != 1 || events[0].Start.Hour() != 9 || events[0].End.Hour() != 14 || events[0].Start.UTC().Hour() != 8 {
+		t.Errorf("Slot is moved by the clock change: %+v", events)
+	}
+}
@@ -2,10 +2,12 @@ package lis
 
 import (
 	"LIS/lis"
+	"bytes"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -135,12 +137,82 @@ func TestBooking(t *testing.T) {
 
 	instance.SetFaketime("2022-11-29")
 	sched.Refresh()
-	if sched.BookIfPossible("Mon", "2pm - 7pm") == nil {
+	if sched.BookIfPossible("Mon", "2pm - 7pm", "To Play") == nil {
 		t.Errorf("Failed to book the room")
 	}
 
 }
 
+func TestCancelBooking(t *testing.T) {
+	testsrvr := httptest.NewServer(
+		http.HandlerFunc(mainHandler),
+	)
+	defer testsrvr.Close()
+	instance := lis.NewInstance(
+		testsrvr.URL,
+		"TEST",
+		"TEST",
+		"TEST",
+	)
+	err := instance.Authorise()
+	if err != nil {
+		t.Error("Auth credentials is not valid for the end user")
+	}
+
+	sched, err := lis.NewSchedule(instance)
+	if err != nil {
+		t.Errorf("Can not create schedule obj with err: %s", err.Error())
+	}
+
+	instance.SetFaketime("2022-11-29")
+	sched.Refresh()
+	if len(sched.MyBookings()) != 0 {
+		t.Errorf("Bookings of other users are reported as mine")
+	}
+	err = sched.CancelBooking(11764275)
+	if err != nil {
+		t.Errorf("Failed to cancel the booking: %s", err.Error())
+	}
+	err = sched.CancelBooking(1)
+	if err == nil {
+		t.Errorf("Cancelling of unknown booking is not failed")
+	}
+}
+
+func TestGrid(t *testing.T) {
+	testsrvr := httptest.NewServer(
+		http.HandlerFunc(mainHandler),
+	)
+	defer testsrvr.Close()
+	instance := lis.NewInstance(testsrvr.URL, "TEST", "TEST", "TEST")
+	err := instance.Authorise()
+	if err != nil {
+		t.Error("Auth credentials is not valid for the end user")
+	}
+	sched, err := lis.NewSchedule(instance)
+	if err != nil {
+		t.Errorf("Can not create schedule obj with err: %s", err.Error())
+	}
+	instance.SetFaketime("2022-11-29")
+	sched.Refresh()
+
+	var out bytes.Buffer
+	lis.WriteGrid(&out, sched.RenderSchedule(), lis.GridOptions{Court: "Cessna 172", Day: "Sun"})
+	grid := out.String()
+	if strings.Contains(grid, "Piper Archer") {
+		t.Errorf("Court filter is not applied:\n%s", grid)
+	}
+	if !strings.Contains(grid, "9am - 11:30pm    X") {
+		t.Errorf("Booked slot is not marked:\n%s", grid)
+	}
+
+	out.Reset()
+	lis.WriteGrid(&out, sched.RenderSchedule(), lis.GridOptions{Court: "Cessna 172", Day: "Sun", FreeOnly: true})
+	if strings.Contains(out.String(), "9am - 11:30pm") {
+		t.Errorf("Booked slot is shown in free-only mode:\n%s", out.String())
+	}
+}
+
 func sendError(w http.ResponseWriter) {
 	w.WriteHeader(403)
 	resp := make(map[string]string)
@@ -263,6 +335,13 @@ func postBookedTimeSlotHandler(w http.ResponseWriter, r *http.Request) {
 	)
 }
 
+func groupHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(
+		[]byte("{\n  \"description\": \"Demo Squash Club\", \n  \"first_day_of_week\": 2, \n  \"groupname\": \"TEST\", \n  \"id\": 1234, \n  \"timezone\": \"UTC\"\n}\n"),
+	)
+}
+
 func mainHandler(w http.ResponseWriter, r *http.Request) {
 	if r.RequestURI == "/sessions" {
 		sessionsHandler(w, r)
@@ -278,6 +357,10 @@ func mainHandler(w http.ResponseWriter, r *http.Request) {
 		bookedTimeSlotHandler(w, r)
 	} else if r.RequestURI == "/bookings" {
 		postBookingHandler(w, r)
+	} else if r.RequestURI == "/bookings/11764275" && r.Method == "DELETE" {
+		w.WriteHeader(204)
+	} else if r.RequestURI == "/groups/1234" {
+		groupHandler(w, r)
 	} else if r.RequestURI == "/booked_time_slots" {
 		postBookedTimeSlotHandler(w, r)
 	} else {
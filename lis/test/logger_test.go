@@ -0,0 +1,96 @@
+package lis
+
+import (
+	"LIS/lis"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLoggerLevels(t *testing.T) {
+	var out bytes.Buffer
+	logger := lis.NewLogger(&out, lis.LevelWarn, false)
+	logger.Log(lis.LevelInfo, "hidden")
+	logger.Log(lis.LevelError, "shown", lis.F("court", "Cessna 172"), lis.F("status", 403))
+	line := out.String()
+	if strings.Contains(line, "hidden") {
+		t.Errorf("Message below the level is logged: %s", line)
+	}
+	if !strings.Contains(line, `ERROR shown court="Cessna 172" status=403`) {
+		t.Errorf("Wrong text line: %s", line)
+	}
+	level, err := lis.ParseLevel("DEBUG")
+	if err != nil || level != lis.LevelDebug {
+		t.Errorf("Level is parsed wrong: %s %v", level, err)
+	}
+	if _, err = lis.ParseLevel("loud"); err == nil {
+		t.Errorf("Unknown level is accepted")
+	}
+}
+
+func TestLoggerLineBreaks(t *testing.T) {
+	var out bytes.Buffer
+	logger := lis.NewLogger(&out, lis.LevelInfo, false)
+	logger.Log(lis.LevelWarn, "request rejected", lis.F("response", "denied\nERROR fake line"), lis.F("body", "a\rb"))
+	line := out.String()
+	if strings.Count(line, "\n") != 1 || strings.Contains(line, "\r") {
+		t.Errorf("Value breaks the line: %q", line)
+	}
+	if !strings.Contains(line, `response="denied\nERROR fake line" body="a\rb"`) {
+		t.Errorf("Value with a line break is not quoted: %q", line)
+	}
+}
+
+func TestLoggerRedaction(t *testing.T) {
+	requestIDs := make(chan string, 10)
+	testsrvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestIDs <- r.Header.Get("X-Request-ID")
+		if r.RequestURI == "/sessions" && r.Method == "POST" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "cookie-value-42"})
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": "password hunter2 is wrong, session cookie-value-42"}`))
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer testsrvr.Close()
+
+	var out bytes.Buffer
+	instance := lis.NewInstance(testsrvr.URL, "TEST", "hunter2", "TEST")
+	instance.SetLogger(lis.NewLogger(&out, lis.LevelDebug, true))
+	if instance.Authorise() == nil {
+		t.Fatal("Wrong credentials are accepted")
+	}
+	logged := out.String()
+	if strings.Contains(logged, "hunter2") || strings.Contains(logged, "cookie-value-42") {
+		t.Errorf("Secrets are logged:\n%s", logged)
+	}
+	if !strings.Contains(logged, "[REDACTED]") {
+		t.Errorf("Rejection is not logged:\n%s", logged)
+	}
+
+	requests := 0
+	for _, line := range strings.Split(strings.TrimSpace(logged), "\n") {
+		entry := make(map[string]interface{})
+		err := json.Unmarshal([]byte(line), &entry)
+		if err != nil {
+			t.Fatalf("Line is not JSON: %s", line)
+		}
+		if entry["msg"] != "request" {
+			continue
+		}
+		requests++
+		if entry["level"] != "debug" || entry["endpoint"] != "sessions" || entry["status"] == nil || entry["duration_ms"] == nil {
+			t.Errorf("Request is logged without the fields: %s", line)
+		}
+		if entry["request_id"] != <-requestIDs {
+			t.Errorf("Request ID is not the one sent: %s", line)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("Requests are not logged:\n%s", logged)
+	}
+}
@@ -0,0 +1,84 @@
+package lis
+
+import (
+	"LIS/lis"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// metricValue finds the value of the series in the exposition, -1 if the
+// series is missing.
+func metricValue(exposition string, series string) float64 {
+	for _, line := range strings.Split(exposition, "\n") {
+		if strings.HasPrefix(line, series+" ") {
+			value, err := strconv.ParseFloat(strings.TrimPrefix(line, series+" "), 64)
+			if err == nil {
+				return value
+			}
+		}
+	}
+	return -1
+}
+
+func TestMetrics(t *testing.T) {
+	testsrvr := httptest.NewServer(
+		http.HandlerFunc(mainHandler),
+	)
+	defer testsrvr.Close()
+	instance := lis.NewInstance(testsrvr.URL, "TEST", "TEST", "TEST")
+	err := instance.Authorise()
+	if err != nil {
+		t.Fatal("Auth credentials is not valid for the end user")
+	}
+	sched, err := lis.NewSchedule(instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sched.SetHistory(nil, "metrics")
+	instance.SetFaketime("2022-11-29")
+	sched.Refresh()
+	if sched.BookCourtIfPossible("Cessna 172", "Mon", "2pm - 7pm", "To Play") == nil {
+		t.Errorf("Failed to book the room")
+	}
+	if sched.BookCourtIfPossible("Cessna 172", "Sun", "9am - 11:30pm", "To Play") != nil {
+		t.Errorf("Booked slot is booked again")
+	}
+
+	metrics := httptest.NewServer(lis.MetricsHandler())
+	defer metrics.Close()
+	resp, err := http.Get(metrics.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	exposition := string(body)
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Wrong content type: %s", resp.Header.Get("Content-Type"))
+	}
+
+	positive := []string{
+		`lis_http_requests_total{method="GET",endpoint="bookings/week",status="200"}`,
+		`lis_http_requests_total{method="POST",endpoint="bookings",status="200"}`,
+		`lis_http_requests_total{method="GET",endpoint="sessions",status="200"}`,
+		`lis_booking_attempts_total{source="metrics"}`,
+		`lis_booking_successes_total{source="metrics"}`,
+		`lis_booking_failures_total{source="metrics"}`,
+		`lis_schedule_refresh_seconds_count`,
+		`lis_schedule_refresh_seconds_bucket{le="+Inf"}`,
+	}
+	for _, series := range positive {
+		if metricValue(exposition, series) <= 0 {
+			t.Errorf("%s is not counted in\n%s", series, exposition)
+		}
+	}
+	if metricValue(exposition, `lis_booking_attempts_total{source="metrics"}`) != 2 {
+		t.Errorf("Attempts are counted wrong in\n%s", exposition)
+	}
+	if metricValue(exposition, "lis_session_reauth_total") < 0 || !strings.Contains(exposition, "# TYPE lis_booking_release_latency_seconds histogram\n") {
+		t.Errorf("Metrics without observations are missing in\n%s", exposition)
+	}
+}
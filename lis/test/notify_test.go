@@ -0,0 +1,125 @@
+package lis
+
+import (
+	"LIS/lis"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type eventChannel chan lis.Event
+
+func (events eventChannel) Notify(event lis.Event) error {
+	events <- event
+	return nil
+}
+
+func waitEvent(t *testing.T, events eventChannel) lis.Event {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("Event is not sent")
+	}
+	return lis.Event{}
+}
+
+func TestWebhook(t *testing.T) {
+	var calls int32
+	received := make(chan lis.Event, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("X-LIS-Signature") != lis.Sign("secret", body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("X-LIS-Event") != lis.EventBookingSucceeded {
+			t.Errorf("Wrong event header: %s", r.Header.Get("X-LIS-Event"))
+		}
+		var event lis.Event
+		json.Unmarshal(body, &event)
+		received <- event
+	}))
+	defer receiver.Close()
+
+	webhook := lis.NewWebhook(receiver.URL, "secret")
+	webhook.Retry = time.Millisecond
+	err := webhook.Notify(lis.Event{Type: lis.EventBookingSucceeded, Date: "2022-11-29", Court: "Cessna 172"})
+	if err != nil {
+		t.Fatalf("Webhook failed: %s", err.Error())
+	}
+	event := <-received
+	if atomic.LoadInt32(&calls) != 2 || event.Court != "Cessna 172" || event.Date != "2022-11-29" {
+		t.Errorf("Event is delivered wrong after %d calls: %+v", calls, event)
+	}
+
+	webhook.Secret = "wrong"
+	err = webhook.Notify(lis.Event{Type: lis.EventBookingSucceeded})
+	if err == nil || atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("Rejected webhook is retried or not reported: %d calls, %v", calls, err)
+	}
+
+	webhook.Events = []string{lis.EventSlotFreed}
+	err = webhook.Notify(lis.Event{Type: lis.EventBookingFailed})
+	if err != nil || atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("Unwanted event is sent")
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	var expired int32
+	testsrvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.RequestURI == "/sessions" && r.Method == "GET" && atomic.CompareAndSwapInt32(&expired, 1, 0) {
+			sendError(w)
+			return
+		}
+		mainHandler(w, r)
+	}))
+	defer testsrvr.Close()
+	instance := lis.NewInstance(testsrvr.URL, "TEST", "TEST", "TEST")
+	events := make(eventChannel, 1)
+	instance.SetNotifier(events)
+	err := instance.Authorise()
+	if err != nil {
+		t.Fatal("Auth credentials is not valid for the end user")
+	}
+
+	atomic.StoreInt32(&expired, 1)
+	err = instance.Authorise()
+	if err != nil {
+		t.Fatalf("Failed to authorise again: %s", err.Error())
+	}
+	event := waitEvent(t, events)
+	if event.Type != lis.EventSessionReauth || event.Username != "TEST" {
+		t.Errorf("Wrong reauth event: %+v", event)
+	}
+
+	job := lis.Job{Name: "weekly", Day: "Tue", Time: "9am - 2pm", Release: "00:00", Attempts: 1}
+	err = job.Prepare()
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon, err := lis.NewDaemon(instance, []lis.Job{job}, time.Minute)
+	if err != nil {
+		t.Fatalf("Can not create the daemon: %s", err.Error())
+	}
+	daemon.RunJob(job, time.Now(), time.Date(2022, 11, 29, 0, 0, 0, 0, time.UTC))
+	event = waitEvent(t, events)
+	if event.Type != lis.EventBookingSucceeded || event.Job != "weekly" || event.Date != "2022-11-29" || event.Court == "" {
+		t.Errorf("Wrong booking event: %+v", event)
+	}
+
+	daemon.RunJob(job, time.Now(), time.Date(2022, 12, 6, 0, 0, 0, 0, time.UTC))
+	event = waitEvent(t, events)
+	if event.Type != lis.EventBookingFailed || event.Error == "" {
+		t.Errorf("Wrong failure event: %+v", event)
+	}
+}
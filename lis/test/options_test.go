@@ -0,0 +1,116 @@
+package lis
+
+import (
+	"LIS/lis"
+	"LIS/lis/fake"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInstanceOptions(t *testing.T) {
+	server := fake.New()
+	var agents, teams int32
+	api := http.NewServeMux()
+	api.Handle("/api/v1/", http.StripPrefix("/api/v1", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") == "lis-test/1.0" {
+			atomic.AddInt32(&agents, 1)
+		}
+		if r.Header.Get("X-Team") == "squash" {
+			atomic.AddInt32(&teams, 1)
+		}
+		server.ServeHTTP(w, r)
+	})))
+	testsrvr := httptest.NewServer(api)
+	defer testsrvr.Close()
+
+	instance := lis.NewInstance("http://unused.invalid", "TEST", "TEST", "TEST",
+		lis.WithBaseURL(testsrvr.URL+"/api/v1/"),
+		lis.WithUserAgent("lis-test/1.0"),
+		lis.WithHeader("X-Team", "squash"),
+	)
+	if instance.GetEndpoint() != testsrvr.URL+"/api/v1" {
+		t.Errorf("Base URL is not set: %s", instance.GetEndpoint())
+	}
+	if instance.Authorise() != nil {
+		t.Fatal("Session is not authorised under the path prefix")
+	}
+	if agents == 0 || agents != teams {
+		t.Errorf("Headers are not sent: %d user agents, %d extra headers", agents, teams)
+	}
+
+	// the timeout of the own client
+	server.SetLatency(200 * time.Millisecond)
+	slow := lis.NewInstance(testsrvr.URL+"/api/v1", "TEST", "TEST", "TEST", lis.WithHTTPClient(&http.Client{Timeout: 20 * time.Millisecond}))
+	if slow.Authorise() == nil {
+		t.Errorf("Timeout of the client is ignored")
+	}
+}
+
+func TestInstanceTLSAndProxy(t *testing.T) {
+	tlssrvr := httptest.NewTLSServer(fake.New())
+	defer tlssrvr.Close()
+	if lis.NewInstance(tlssrvr.URL, "TEST", "TEST", "TEST").Authorise() == nil {
+		t.Errorf("Unknown certificate is trusted")
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(tlssrvr.Certificate())
+	instance := lis.NewInstance(tlssrvr.URL, "TEST", "TEST", "TEST", lis.WithTLSConfig(&tls.Config{RootCAs: roots}))
+	if instance.Authorise() != nil {
+		t.Errorf("Session is not authorised with the TLS config")
+	}
+
+	// the proxy gets the requests to the club and serves them itself
+	var proxied int32
+	server := fake.New()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Host == "club.invalid" {
+			atomic.AddInt32(&proxied, 1)
+		}
+		server.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	instance = lis.NewInstance("http://club.invalid", "TEST", "TEST", "TEST", lis.WithProxy(proxyURL))
+	if instance.Authorise() != nil || proxied == 0 {
+		t.Errorf("Requests don't go through the proxy")
+	}
+}
+
+func TestScheduleAPI(t *testing.T) {
+	_, sched := fakeClub(t, "TEST")
+
+	// a middleware counting the requests
+	requests := make([]string, 0)
+	next := sched.API()
+	sched.SetAPI(lis.APIFunc(func(method string, handler string, payload []byte) (int, []byte, error) {
+		requests = append(requests, method+" "+handler)
+		return next.Request(method, handler, payload)
+	}))
+	if sched.Refresh() != nil {
+		t.Fatal("Schedule is not refreshed through the middleware")
+	}
+	if len(requests) != 5 || requests[3] != "GET bookings/week/2022/11/29" {
+		t.Errorf("Requests are not passed through the middleware: %v", requests)
+	}
+
+	// a mock refusing the bookings
+	sched.SetAPI(lis.APIFunc(func(method string, handler string, payload []byte) (int, []byte, error) {
+		if method == "POST" {
+			return 503, []byte(`{"error": "unavailable"}`), nil
+		}
+		return next.Request(method, handler, payload)
+	}))
+	if sched.BookIfPossible("Tue", "2pm - 7pm", "To Play") != nil {
+		t.Errorf("Refused booking is reported as booked")
+	}
+	if sched.CancelBooking(1) == nil || !strings.Contains(sched.CancelBooking(1).Error(), "status 404") {
+		t.Errorf("Cancel of unknown booking is not refused")
+	}
+}
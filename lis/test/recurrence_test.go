@@ -0,0 +1,41 @@
+package lis
+
+import (
+	"LIS/lis"
+	"testing"
+	"time"
+)
+
+func TestRecurrence(t *testing.T) {
+	rule := lis.Recurrence{
+		Days:  []string{"Tue", "Thu"},
+		Every: 2,
+		Start: "2022-11-29",
+		Until: "2022-12-31",
+		Skip:  []string{"2022-12-13"},
+	}
+	err := rule.Prepare()
+	if err != nil {
+		t.Fatalf("Valid recurrence is rejected: %s", err.Error())
+	}
+	from := time.Date(2022, 11, 28, 0, 0, 0, 0, time.UTC)
+	expected := []string{"2022-11-29", "2022-12-01", "2022-12-15", "2022-12-27", "2022-12-29"}
+	dates := rule.Occurrences(from, 10)
+	if len(dates) != len(expected) {
+		t.Fatalf("Wrong number of occurrences: %v", dates)
+	}
+	for index, date := range dates {
+		if date.Format("2006-01-02") != expected[index] {
+			t.Errorf("Occurrence %d is %s instead of %s", index, date.Format("2006-01-02"), expected[index])
+		}
+	}
+
+	bad := lis.Recurrence{Days: []string{"Tue"}, Every: 3}
+	if bad.Prepare() == nil {
+		t.Errorf("Every 3 weeks without start is accepted")
+	}
+	bad = lis.Recurrence{Days: []string{"Tue"}, Skip: []string{"13.12.2022"}}
+	if bad.Prepare() == nil {
+		t.Errorf("Wrong skip date is accepted")
+	}
+}
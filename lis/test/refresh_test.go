@@ -0,0 +1,57 @@
+package lis
+
+import (
+	"LIS/lis"
+	"LIS/lis/fake"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRefreshErrors(t *testing.T) {
+	server, sched := fakeClub(t, "TEST")
+	server.Book(123, 77787, 759165, "2022-11-29", "Mine")
+	err := sched.Refresh()
+	if err != nil {
+		t.Fatalf("Schedule is not refreshed: %s", err.Error())
+	}
+
+	for _, path := range []string{"/users", "/resources", "/time_slots", "/bookings/week", "/booked_time_slots/week"} {
+		server.Fail(fake.Failure{Method: "GET", Path: path, Status: 500, Times: 1})
+		err = sched.Refresh()
+		if err == nil || !strings.Contains(err.Error(), "500") {
+			t.Errorf("Failure of %s is not returned: %v", path, err)
+		}
+		// the schedule read before is kept
+		if len(sched.RenderSchedule()) != 2 || len(sched.MyBookings()) != 1 {
+			t.Errorf("Schedule is lost after the failure of %s", path)
+		}
+	}
+
+	sched.SetAPI(lis.APIFunc(func(method string, handler string, payload []byte) (int, []byte, error) {
+		return 0, nil, fmt.Errorf("connection refused")
+	}))
+	err = sched.Refresh()
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("Network error is not returned: %v", err)
+	}
+	sched.SetAPI(lis.APIFunc(func(method string, handler string, payload []byte) (int, []byte, error) {
+		return 200, []byte("<html>"), nil
+	}))
+	if sched.Refresh() == nil {
+		t.Errorf("Broken response is accepted")
+	}
+}
+
+func TestRefreshErrorOnCommandLine(t *testing.T) {
+	server, connect := fakeCLI(t, "TEST")
+	server.Fail(fake.Failure{Method: "GET", Path: "/time_slots", Status: 503})
+
+	result := runCLI(t, t.TempDir(), command("show", connect, "-o", "json")...)
+	failure := lis.ErrorResult{}
+	err := json.Unmarshal([]byte(result.stdout), &failure)
+	if err != nil || result.code != 1 || !strings.Contains(failure.Error, "503") {
+		t.Errorf("Failure of the schedule is not reported (%d): %s", result.code, result.stdout)
+	}
+}
@@ -0,0 +1,105 @@
+package lis
+
+import (
+	"LIS/lis"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRules(t *testing.T) {
+	server, sched := fakeClub(t, "TEST")
+	history := lis.NewHistory(filepath.Join(t.TempDir(), "history.jsonl"))
+	sched.SetHistory(history, "book")
+	rules := &lis.Rules{BannedCourts: []string{"Cessna 172"}, MaxPrime: 1, NoBackToBack: true}
+	if rules.Prepare() != nil {
+		t.Fatal("Valid rules are rejected")
+	}
+	sched.SetRules(rules)
+	sched.Refresh()
+
+	booked := sched.BookIfPossible("Tue", "2pm - 7pm", "To Play")
+	if booked == nil || *booked != "Piper Archer" {
+		t.Fatalf("Banned court is booked or the other one is not")
+	}
+	sched.Refresh()
+	if sched.BookIfPossible("Wed", "2pm - 7pm", "To Play") != nil {
+		t.Errorf("Second prime slot is booked")
+	}
+	if sched.BookIfPossible("Tue", "9am - 2pm", "To Play") != nil {
+		t.Errorf("Back to back slot is booked")
+	}
+	booked = sched.BookIfPossible("Wed", "9am - 2pm", "To Play")
+	if booked == nil {
+		t.Errorf("Slot allowed by the rules is not booked")
+	}
+	if len(server.Bookings()) != 2 {
+		t.Errorf("Wrong bookings: %+v", server.Bookings())
+	}
+	attempts, _ := history.Load()
+	if len(attempts) != 4 || !strings.Contains(attempts[1].Failure, "rule max_prime") || !strings.Contains(attempts[2].Failure, "rule no_back_to_back") {
+		t.Errorf("Violations are not recorded: %+v", attempts)
+	}
+
+	sched.SetRules(&lis.Rules{MaxPerWeek: 2})
+	sched.Refresh()
+	if sched.BookIfPossible("Thu", "9am - 2pm", "To Play") != nil {
+		t.Errorf("Booking over the weekly limit is made")
+	}
+}
+
+func TestRulesMinNotice(t *testing.T) {
+	server, sched := fakeClub(t, "TEST")
+	rules := &lis.Rules{MinNotice: "1h"}
+	if rules.Prepare() != nil {
+		t.Fatal("Valid notice is rejected")
+	}
+	sched.SetRules(rules)
+	sched.Refresh()
+	if sched.BookIfPossible("Tue", "9am - 2pm", "To Play") != nil {
+		t.Errorf("Past slot is booked")
+	}
+
+	game := time.Now().AddDate(0, 0, 14)
+	sched.Client().SetFaketime(game.Format("2006-01-02"))
+	sched.Refresh()
+	if sched.BookIfPossible(game.Format("Mon"), "9am - 2pm", "To Play") == nil {
+		t.Errorf("Slot with enough notice is not booked")
+	}
+	rules = &lis.Rules{MinNotice: "720h"}
+	rules.Prepare()
+	sched.SetRules(rules)
+	if sched.BookIfPossible(game.Format("Mon"), "2pm - 7pm", "To Play") != nil {
+		t.Errorf("Slot without enough notice is booked")
+	}
+	// the timezone of every cell checked comes from the group read once
+	reads := 0
+	for _, request := range server.Requests() {
+		if strings.HasPrefix(request, "GET /groups/") {
+			reads++
+		}
+	}
+	if reads != 1 {
+		t.Errorf("Group is read %d times", reads)
+	}
+
+	if (&lis.Rules{MinNotice: "soon"}).Prepare() == nil || (&lis.Rules{MaxPerWeek: -1}).Prepare() == nil {
+		t.Errorf("Wrong rules are accepted")
+	}
+}
+
+func TestProfileRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	ioutil.WriteFile(path, []byte("profiles:\n  default:\n    rules:\n      max_prime: 2\n      min_notice: 3h\n      banned_courts: [\"Court 3\"]\n"), 0600)
+	profile, err := lis.LoadProfile(path, "")
+	if err != nil || profile.Rules == nil || profile.Rules.MaxPrime != 2 || len(profile.Rules.BannedCourts) != 1 {
+		t.Errorf("Rules are not loaded: %+v %v", profile, err)
+	}
+	ioutil.WriteFile(path, []byte("profiles:\n  default:\n    rules:\n      min_notice: soon\n"), 0600)
+	_, err = lis.LoadProfile(path, "")
+	if err == nil {
+		t.Errorf("Wrong rules are loaded")
+	}
+}
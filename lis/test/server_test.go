@@ -0,0 +1,99 @@
+package lis
+
+import (
+	"LIS/lis"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func apiRequest(t *testing.T, api *httptest.Server, method string, path string, token string, body []byte) *http.Response {
+	req, err := http.NewRequest(method, api.URL+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestServer(t *testing.T) {
+	testsrvr := httptest.NewServer(
+		http.HandlerFunc(mainHandler),
+	)
+	defer testsrvr.Close()
+	instance := lis.NewInstance(testsrvr.URL, "TEST", "TEST", "TEST")
+	err := instance.Authorise()
+	if err != nil {
+		t.Fatal("Auth credentials is not valid for the end user")
+	}
+	_, err = lis.NewServer(instance, "")
+	if err == nil {
+		t.Errorf("Server without API token is created")
+	}
+	server, err := lis.NewServer(instance, "secret")
+	if err != nil {
+		t.Fatalf("Can not create the server: %s", err.Error())
+	}
+	api := httptest.NewServer(server)
+	defer api.Close()
+
+	resp := apiRequest(t, api, "GET", "/", "", nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("Web UI is not served: %d", resp.StatusCode)
+	}
+
+	resp = apiRequest(t, api, "GET", "/schedule?date=2022-11-29", "wrong", nil)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Wrong token is accepted: %d", resp.StatusCode)
+	}
+
+	resp = apiRequest(t, api, "GET", "/schedule?date=2022-11-29", "secret", nil)
+	var schedule lis.ScheduleResult
+	json.NewDecoder(resp.Body).Decode(&schedule)
+	if resp.StatusCode != http.StatusOK || len(schedule.TimeTables) != 2 {
+		t.Fatalf("Wrong schedule response %d: %+v", resp.StatusCode, schedule)
+	}
+	if schedule.TimeTables[0].Days[0].Date != "2022-12-04" || schedule.TimeTables[0].Days[1].Date != "2022-11-28" {
+		t.Errorf("Wrong dates of the week: %s, %s", schedule.TimeTables[0].Days[0].Date, schedule.TimeTables[0].Days[1].Date)
+	}
+
+	resp = apiRequest(t, api, "GET", "/bookings/mine?date=2022-11-29", "secret", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Wrong bookings response %d", resp.StatusCode)
+	}
+	resp = apiRequest(t, api, "GET", "/bookings/mine?date=29.11.2022", "secret", nil)
+	if resp.StatusCode == http.StatusOK {
+		t.Errorf("Wrong date is accepted")
+	}
+
+	body, _ := json.Marshal(lis.APIBookingRequest{Date: "2022-11-29", Time: "9am - 2pm", Courts: []string{"Cessna 172"}})
+	resp = apiRequest(t, api, "POST", "/bookings", "secret", body)
+	var booking lis.BookingResult
+	json.NewDecoder(resp.Body).Decode(&booking)
+	if resp.StatusCode != http.StatusCreated || !booking.Booked || booking.Court != "Cessna 172" || booking.Day != "Tue" {
+		t.Errorf("Wrong booking response %d: %+v", resp.StatusCode, booking)
+	}
+
+	body, _ = json.Marshal(lis.APIBookingRequest{Date: "2022-11-29"})
+	resp = apiRequest(t, api, "POST", "/bookings", "secret", body)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Booking without time is not rejected: %d", resp.StatusCode)
+	}
+
+	resp = apiRequest(t, api, "DELETE", "/bookings/11764275", "secret", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Wrong cancel response %d", resp.StatusCode)
+	}
+	resp = apiRequest(t, api, "GET", "/bookings", "secret", nil)
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Wrong method is accepted: %d", resp.StatusCode)
+	}
+}
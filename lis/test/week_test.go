@@ -0,0 +1,76 @@
+package lis
+
+import (
+	"LIS/lis"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWeekStart(t *testing.T) {
+	tuesday := time.Date(2022, 11, 29, 0, 0, 0, 0, time.UTC)
+	for first, expected := range map[int]string{
+		0: "2022-11-28", // unknown, Monday
+		1: "2022-11-27", // Sunday
+		2: "2022-11-28", // Monday
+		3: "2022-11-29", // Tuesday
+		4: "2022-11-23", // Wednesday
+		7: "2022-11-26", // Saturday
+	} {
+		start := lis.WeekStart(tuesday, first)
+		if start.Format("2006-01-02") != expected {
+			t.Errorf("Week starting on day %d starts on %s, expected %s", first, start.Format("2006-01-02"), expected)
+		}
+	}
+}
+
+// The slots of a day are booked on the date of that day within the week of
+// the API, which starts on the first day of the week of the group.
+func TestBookingDateOfWeek(t *testing.T) {
+	for first, expected := range map[int]map[string]string{
+		2: {"Mon": "2022-11-28", "Tue": "2022-11-29", "Sun": "2022-12-04"},
+		1: {"Mon": "2022-11-28", "Tue": "2022-11-29", "Sun": "2022-11-27"},
+	} {
+		server, sched := fakeClub(t, "TEST")
+		group := server.Group()
+		group.FirstDayOfWeek = first
+		server.SetGroup(group)
+		sched.Refresh()
+		for _, day := range []string{"Mon", "Tue", "Sun"} {
+			if sched.BookIfPossible(day, "2pm - 7pm", "To Play") == nil {
+				t.Fatalf("%s is not booked in the week starting on day %d", day, first)
+			}
+		}
+		sched.Refresh()
+		dates := make(map[string]string)
+		for _, booking := range sched.MyBookings() {
+			dates[booking.Day] = booking.Date
+		}
+		for day, date := range expected {
+			if dates[day] != date {
+				t.Errorf("%s is booked on %s in the week starting on day %d, expected %s", day, dates[day], first, date)
+			}
+		}
+	}
+}
+
+func TestWeekStartReadOnce(t *testing.T) {
+	server, sched := fakeClub(t, "TEST")
+	group := server.Group()
+	group.FirstDayOfWeek = 0
+	server.SetGroup(group)
+	sched.Refresh()
+	sched.RenderSchedule()
+	if sched.BookIfPossible("Tue", "2pm - 7pm", "To Play") == nil {
+		t.Fatal("Slot is not booked")
+	}
+	reads := 0
+	for _, request := range server.Requests() {
+		if strings.HasPrefix(request, "GET /groups/") {
+			reads++
+		}
+	}
+	if reads != 1 {
+		t.Errorf("Group without the first day of the week is read %d times", reads)
+	}
+}
@@ -0,0 +1,103 @@
+package lis
+
+import (
+	"fmt"
+	"time"
+)
+
+// Watch polls the schedule every interval until the requested cell becomes
+// free and is booked. A zero timeout means watching forever. Only the last
+// poll is recorded. A slot which is mine already is returned without
+// notifying, AlreadyHeld tells so.
+func (sched *Schedule) Watch(courts []string, day string, slot string, description string, interval time.Duration, timeout time.Duration) *string {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		booked, attempt, result := sched.tryRefreshed(courts, day, slot, description)
+		sched.held = result == held
+		if result == held {
+			return booked
+		}
+		if booked != nil {
+			sched.record(attempt)
+			sched.notifyResult(EventBookingSucceeded, day, slot, *booked, "")
+			return booked
+		}
+		if !deadline.IsZero() && time.Now().Add(interval).After(deadline) {
+			sched.log(LevelWarn, "watching is timed out", F("day", day), F("time", slot))
+			attempt.fail("watching is timed out")
+			sched.record(attempt)
+			sched.notifyResult(EventBookingFailed, day, slot, "", "watching is timed out")
+			return nil
+		}
+		sched.log(LevelInfo, "slot is not available", F("day", day), F("time", slot), F("next_check", interval.String()))
+		time.Sleep(interval)
+	}
+}
+
+// Snipe waits until the release moment and then tries to book the requested
+// cell up to attempts times, pausing retry between the attempts. Only the
+// outcome of the attempts is recorded, the tries skipped while another
+// process books the slot are not. A slot which is mine already is returned
+// like in Watch.
+func (sched *Schedule) Snipe(at time.Time, courts []string, day string, slot string, description string, retry time.Duration, attempts int) *string {
+	wait := time.Until(at)
+	if wait > 0 {
+		sched.log(LevelInfo, "waiting for the release", F("wait", wait.String()), F("release", at.Format(time.RFC3339)))
+		time.Sleep(wait)
+	}
+	var last *Attempt
+	for attempt := 1; attempt <= attempts; attempt++ {
+		booked, try, result := sched.tryRefreshed(courts, day, slot, description)
+		sched.held = result == held
+		if result == held {
+			return booked
+		}
+		if result == tried {
+			last = &try
+		}
+		if booked != nil {
+			sched.record(*last)
+			sched.notifyResult(EventBookingSucceeded, day, slot, *booked, "")
+			return booked
+		}
+		sched.log(LevelWarn, "snipe attempt failed", F("attempt", attempt), F("attempts", attempts))
+		if attempt < attempts {
+			time.Sleep(retry)
+		}
+	}
+	if last != nil {
+		sched.record(*last)
+	}
+	sched.notifyResult(EventBookingFailed, day, slot, "", fmt.Sprintf("not booked in %d attempts", attempts))
+	return nil
+}
+
+// tryRefreshed refreshes the schedule and tries the courts like
+// tryPreferred, a failed refresh is the failure of the attempt.
+func (sched *Schedule) tryRefreshed(courts []string, day string, slot string, description string) (*string, Attempt, tryResult) {
+	err := sched.Refresh()
+	if err != nil {
+		attempt := sched.newAttempt(day, slot)
+		attempt.fail(err.Error())
+		return nil, attempt, tried
+	}
+	return sched.tryPreferred(courts, day, slot, description)
+}
+
+func (sched *Schedule) notifyResult(eventType string, day string, slot string, court string, failure string) {
+	if sched.dryRun {
+		return
+	}
+	sched.session.notify(Event{
+		Type:  eventType,
+		Date:  sched.dateOf(day).Format("2006-01-02"),
+		Day:   day,
+		Time:  slot,
+		Court: court,
+		For:   sched.bookerName(),
+		Error: failure,
+	})
+}
@@ -0,0 +1,21 @@
+package lis
+
+import (
+	"embed"
+	"net/http"
+)
+
+//go:embed web/index.html
+var webFS embed.FS
+
+// serveUI sends the page of the web UI. The page itself holds no data, it
+// asks for the API token and uses the REST API, so it's served without one.
+func serveUI(w http.ResponseWriter, r *http.Request) {
+	page, err := webFS.ReadFile("web/index.html")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "web UI is not available")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page)
+}
@@ -5,5 +5,5 @@ import (
 )
 
 func main() {
-	lis.Book()
+	lis.Run()
 }